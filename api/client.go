@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"strconv"
 
 	"github.com/curtisnewbie/miso/miso"
 )
@@ -12,12 +13,23 @@ import (
 var (
 	ErrFileNotFound  = errors.New("file not found")
 	ErrFileDeleted   = errors.New("file deleted")
+	ErrFileRemoved   = errors.New("file removed physically")
 	ErrIllegalFormat = errors.New("illegal format")
 
 	ErrMapper = map[string]error{
 		FileNotFound:  ErrFileNotFound,
 		FileDeleted:   ErrFileDeleted,
+		FileRemoved:   ErrFileRemoved,
 		IllegalFormat: ErrIllegalFormat,
+
+		InvalidMedia:           ErrIllegalFormat,
+		NotMediaFile:           ErrIllegalFormat,
+		NotImage:               ErrIllegalFormat,
+		NotJpeg:                ErrIllegalFormat,
+		UnsupportedImageFormat: ErrIllegalFormat,
+		NotDocument:            ErrIllegalFormat,
+		NotHlsPlaylist:         ErrIllegalFormat,
+		NotZipFile:             ErrIllegalFormat,
 	}
 )
 
@@ -35,6 +47,18 @@ func FetchFileInfo(rail miso.Rail, req FetchFileInfoReq) (FstoreFile, error) {
 	return r.MappedRes(ErrMapper)
 }
 
+// Fetch info of files in batch, one result is returned for each requested fileId and uploadFileId in order (fileIds first).
+func FetchFileInfos(rail miso.Rail, req FetchFileInfosReq) ([]FileInfoResult, error) {
+	var r miso.GnResp[[]FileInfoResult]
+	err := miso.NewDynTClient(rail, "/file/info/batch", "fstore").
+		PostJson(req).
+		Json(&r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch mini-fstore fileInfos, %w", err)
+	}
+	return r.MappedRes(ErrMapper)
+}
+
 func DeleteFile(rail miso.Rail, fileId string) error {
 	var r miso.GnResp[any]
 	err := miso.NewDynTClient(rail, "/file", "fstore").
@@ -49,6 +73,65 @@ func DeleteFile(rail miso.Rail, fileId string) error {
 	return err
 }
 
+// Mark files as deleted in batch, returns result of each file in order.
+func DeleteFiles(rail miso.Rail, fileIds []string) ([]FileOpResult, error) {
+	var r miso.GnResp[[]FileOpResult]
+	err := miso.NewDynTClient(rail, "/file/delete/batch", "fstore").
+		PostJson(FileIdsReq{FileIds: fileIds}).
+		Json(&r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete mini-fstore files, %v", err)
+	}
+	return r.MappedRes(ErrMapper)
+}
+
+// Restore logically deleted files in batch, returns result of each file in order.
+//
+// Files that have been removed physically can't be restored, ErrCode of these files is FILE_REMOVED.
+func UndeleteFiles(rail miso.Rail, fileIds []string) ([]FileOpResult, error) {
+	var r miso.GnResp[[]FileOpResult]
+	err := miso.NewDynTClient(rail, "/file/undelete", "fstore").
+		PostJson(FileIdsReq{FileIds: fileIds}).
+		Json(&r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to undelete mini-fstore files, %v", err)
+	}
+	return r.MappedRes(ErrMapper)
+}
+
+// Restore deleted files in batch, returns result of each file in order.
+//
+// Unlike UndeleteFiles, files that have been removed physically using the 'trash' strategy are restored as well,
+// ErrCode is FILE_REMOVED only if the file is no longer in the trash.
+func RestoreFiles(rail miso.Rail, fileIds []string) ([]FileOpResult, error) {
+	var r miso.GnResp[[]FileOpResult]
+	err := miso.NewDynTClient(rail, "/file/restore", "fstore").
+		PostJson(FileIdsReq{FileIds: fileIds}).
+		Json(&r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore mini-fstore files, %v", err)
+	}
+	return r.MappedRes(ErrMapper)
+}
+
+// Restore logically deleted file, ErrFileRemoved is returned if the file has been removed physically.
+func UndeleteFile(rail miso.Rail, fileId string) error {
+	res, err := UndeleteFiles(rail, []string{fileId})
+	if err != nil {
+		return err
+	}
+	for _, v := range res {
+		if v.Ok {
+			continue
+		}
+		if e, ok := ErrMapper[v.ErrCode]; ok {
+			return e
+		}
+		return fmt.Errorf("failed to undelete mini-fstore file, fileId: %v, %v", fileId, v.Error)
+	}
+	return nil
+}
+
 func GenTempFileKey(rail miso.Rail, fileId string, filename string) (string, error) {
 	var r miso.GnResp[string]
 	err := miso.NewDynTClient(rail, "/file/key", "fstore").
@@ -64,6 +147,61 @@ func GenTempFileKey(rail miso.Rail, fileId string, filename string) (string, err
 	return r.MappedRes(ErrMapper)
 }
 
+// Generate temporary file key restricted by the options in req.
+func GenScopedFileKey(rail miso.Rail, req GenFileKeyReq) (string, error) {
+	var r miso.GnResp[string]
+	c := miso.NewDynTClient(rail, "/file/key", "fstore").
+		AddQueryParams("fileId", req.FileId).
+		AddQueryParams("filename", url.QueryEscape(req.Filename))
+	if req.Scope != "" {
+		c.AddQueryParams("scope", req.Scope)
+	}
+	if req.MaxUses > 0 {
+		c.AddQueryParams("maxUses", strconv.Itoa(req.MaxUses))
+	}
+	if req.ExpireAt > 0 {
+		c.AddQueryParams("expireAt", strconv.FormatInt(req.ExpireAt, 10))
+	}
+	if req.ClientIp != "" {
+		c.AddQueryParams("clientIp", req.ClientIp)
+	}
+	if req.Referer != "" {
+		c.AddQueryParams("referer", req.Referer)
+	}
+	err := c.Get().Json(&r)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate mini-fstore temp token, req: %+v, %v", req, err)
+	}
+	return r.MappedRes(ErrMapper)
+}
+
+// Generate temporary file keys in batch, returns file_id -> file key.
+//
+// All the files must exist, otherwise ErrFileNotFound is returned and no key is generated.
+func GenTempFileKeys(rail miso.Rail, req GenFileKeysReq) (map[string]string, error) {
+	var r miso.GnResp[map[string]string]
+	err := miso.NewDynTClient(rail, "/file/key/batch", "fstore").
+		PostJson(req).
+		Json(&r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate mini-fstore temp tokens, %v", err)
+	}
+	return r.MappedRes(ErrMapper)
+}
+
+// Revoke temporary file key, or all the temporary file keys of the file.
+func RevokeFileKey(rail miso.Rail, req RevokeFileKeyReq) error {
+	var r miso.GnResp[any]
+	err := miso.NewDynTClient(rail, "/file/key/revoke", "fstore").
+		PostJson(req).
+		Json(&r)
+	if err != nil {
+		return fmt.Errorf("failed to revoke mini-fstore file key, req: %+v, %v", req, err)
+	}
+	_, err = r.MappedRes(ErrMapper)
+	return err
+}
+
 func DownloadFile(rail miso.Rail, tmpToken string, writer io.Writer) error {
 	_, err := miso.NewDynTClient(rail, "/file/raw", "fstore").
 		AddQueryParams("key", tmpToken).
@@ -96,6 +234,31 @@ func TriggerFileUnzip(rail miso.Rail, req UnzipFileReq) error {
 	return err
 }
 
+// Strip EXIF (including GPS), XMP and IPTC metadata from the jpeg file, a new file is created and it's fileId is returned.
+func StripImageMetadata(rail miso.Rail, fileId string) (string, error) {
+	var r miso.GnResp[string]
+	err := miso.NewDynTClient(rail, "/file/strip-metadata", "fstore").
+		PostJson(StripMetadataReq{FileId: fileId}).
+		Json(&r)
+	if err != nil {
+		return "", fmt.Errorf("failed to strip mini-fstore file metadata, fileId: %v, %v", fileId, err)
+	}
+	return r.MappedRes(ErrMapper)
+}
+
+// Trigger media metadata extraction of image, video or audio asynchronously, use FetchFileInfo to retrieve the metadata later.
+func TriggerMetadataExtraction(rail miso.Rail, fileId string) error {
+	var r miso.GnResp[any]
+	err := miso.NewDynTClient(rail, "/file/metadata/extract", "fstore").
+		PostJson(ExtractMetadataReq{FileId: fileId}).
+		Json(&r)
+	if err != nil {
+		return fmt.Errorf("failed to trigger mini-fstore metadata extraction, fileId: %v, %v", fileId, err)
+	}
+	_, err = r.MappedRes(ErrMapper)
+	return err
+}
+
 type DirectDownloadFileReq struct {
 	FileId string
 }
@@ -124,3 +124,20 @@ func TestDownloadFileDirect(t *testing.T) {
 	}
 	t.Log(string(buf))
 }
+
+func TestStripImageMetadata(t *testing.T) {
+	rail := _clientPreTest(t)
+	fileId, err := StripImageMetadata(rail, "file_1065472450510848960196")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(fileId)
+}
+
+func TestTriggerMetadataExtraction(t *testing.T) {
+	rail := _clientPreTest(t)
+	err := TriggerMetadataExtraction(rail, "file_1065472450510848960196")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,6 +5,18 @@ const (
 	InvalidRequest       = "INVALID_REQUEST"
 	FileNotFound         = "FILE_NOT_FOUND"
 	FileDeleted          = "FILE_DELETED"
+	FileRemoved          = "FILE_REMOVED"
 	IllegalFormat        = "ILLEGAL_FORMAT"
 	InvalidAuthorization = "INVALID_AUTHORIZATION"
+	ImageTooLarge        = "IMAGE_TOO_LARGE"
+
+	// more specific codes of IllegalFormat, these are all mapped to ErrIllegalFormat in ErrMapper
+	InvalidMedia           = "INVALID_MEDIA"
+	NotMediaFile           = "NOT_MEDIA_FILE"
+	NotImage               = "NOT_IMAGE"
+	NotJpeg                = "NOT_JPEG"
+	UnsupportedImageFormat = "UNSUPPORTED_IMAGE_FORMAT"
+	NotDocument            = "NOT_DOCUMENT"
+	NotHlsPlaylist         = "NOT_HLS_PLAYLIST"
+	NotZipFile             = "NOT_ZIP_FILE"
 )
@@ -8,20 +8,87 @@ const (
 	FileStatusPhysicDel = "PHY_DEL" // file.status - physically deleted
 )
 
+type GenFileKeyReq struct {
+	FileId   string
+	Filename string // the name that will be used when downloading the file
+	Scope    string // FileOpDownload or FileOpStream, both are allowed if empty
+	MaxUses  int    // max number of downloads, requires Scope to be api.FileOpDownload, unlimited if zero
+	ExpireAt int64  // absolute expiry (unix timestamp in seconds), the key never outlives it even if it's refreshed
+	ClientIp string // bind the key to the client ip
+	Referer  string // bind the key to the referer (prefix)
+}
+
+type FileKeyItem struct {
+	FileId   string `json:"fileId" desc:"actual file_id of the file record"`
+	Filename string `json:"filename" desc:"the name that will be used when downloading the file"`
+}
+
+type GenFileKeysReq struct {
+	Files    []FileKeyItem `json:"files" desc:"files that the keys are generated for, at most 1000 files"`
+	Scope    string        `json:"scope" desc:"restrict the keys to 'download' or 'stream', both are allowed if empty"`
+	MaxUses  int           `json:"maxUses" desc:"max number of downloads of each key, scope must be download"`
+	ExpireAt int64         `json:"expireAt" desc:"absolute expiry (unix timestamp in seconds), the keys never outlive it even if they are refreshed"`
+	ClientIp string        `json:"clientIp" desc:"bind the keys to the client ip"`
+	Referer  string        `json:"referer" desc:"bind the keys to the referer (prefix)"`
+}
+
+type FileIdsReq struct {
+	FileIds []string `json:"fileIds" desc:"actual file_ids of the file records, at most 1000 files"`
+}
+
+type FileOpResult struct {
+	FileId  string `json:"fileId" desc:"file_id"`
+	Ok      bool   `json:"ok" desc:"whether the operation succeeded"`
+	ErrCode string `json:"errCode" desc:"error code if the operation failed, e.g., FILE_NOT_FOUND, FILE_DELETED, FILE_REMOVED"`
+	Error   string `json:"error" desc:"error message if the operation failed"`
+}
+
+type RevokeFileKeyReq struct {
+	Key    string `json:"key" desc:"temporary file key to be revoked"`
+	FileId string `json:"fileId" desc:"file_id, all the temporary file keys of the file are revoked"`
+}
+
 type FetchFileInfoReq struct {
 	FileId       string
 	UploadFileId string
 }
 
+type FetchFileInfosReq struct {
+	FileIds       []string `json:"fileIds" desc:"actual file_ids of the file records"`
+	UploadFileIds []string `json:"uploadFileIds" desc:"temporary file_ids returned when uploading files"`
+}
+
+type FileInfoResult struct {
+	FileId       string      `json:"fileId" desc:"requested file_id, empty if the file is requested by uploadFileId"`
+	UploadFileId string      `json:"uploadFileId" desc:"requested uploadFileId, empty if the file is requested by file_id"`
+	Found        bool        `json:"found" desc:"whether the file is found, uploadFileId may have expired"`
+	File         *FstoreFile `json:"file" desc:"file info, null if not found"`
+}
+
 type FstoreFile struct {
 	FileId     string      `json:"fileId" desc:"file unique identifier"`
 	Name       string      `json:"name" desc:"file name"`
 	Status     string      `json:"status" desc:"status, 'NORMAL', 'LOG_DEL' (logically deleted), 'PHY_DEL' (physically deleted)"`
 	Size       int64       `json:"size" desc:"file size in bytes"`
 	Md5        string      `json:"md5" desc:"MD5 checksum"`
+	Sha1       string      `json:"sha1" desc:"SHA1 checksum, empty if not computed yet"`
 	UplTime    util.ETime  `json:"uplTime" desc:"upload time"`
 	LogDelTime *util.ETime `json:"logDelTime" desc:"logically deleted at"`
 	PhyDelTime *util.ETime `json:"phyDelTime" desc:"physically deleted at"`
+
+	Metadata *FileMetadata `json:"metadata" desc:"media metadata, null if absent"`
+}
+
+type FileMetadata struct {
+	Format      string      `json:"format" desc:"media format, e.g., jpeg, png, or container format of video (reported by ffprobe)"`
+	Width       int         `json:"width" desc:"width in pixels"`
+	Height      int         `json:"height" desc:"height in pixels"`
+	CaptureTime *util.ETime `json:"captureTime" desc:"when the photo was taken"`
+	CameraModel string      `json:"cameraModel" desc:"camera model"`
+	DurationMs  int64       `json:"durationMs" desc:"duration of video in milliseconds"`
+	VideoCodec  string      `json:"videoCodec" desc:"video codec, e.g., h264"`
+	AudioCodec  string      `json:"audioCodec" desc:"audio codec, e.g., aac"`
+	Bitrate     int64       `json:"bitrate" desc:"bitrate in bits per second"`
 }
 
 type UnzipFileReq struct {
@@ -36,3 +103,52 @@ type UnzipFileReq struct {
 	// Extra information that will be passed back to the caller in reply event.
 	Extra string `desc:"extra information that will be passed around for the caller"`
 }
+
+type StripMetadataReq struct {
+	FileId string `valid:"notEmpty" desc:"file_id of the jpeg file"`
+}
+
+type ExtractMetadataReq struct {
+	FileId string `valid:"notEmpty" desc:"file_id of the image, video or audio"`
+}
+
+// Waveform of audio file, stored as json file in mini-fstore.
+type Waveform struct {
+	DurationMs int64     `json:"durationMs"` // duration of the audio in milliseconds
+	Peaks      []float64 `json:"peaks"`      // normalized peaks (0-1), evenly spaced across the whole audio
+}
+
+type ListDerivativesReq struct {
+	SourceSha1 string   `json:"sourceSha1" valid:"notEmpty" desc:"sha1 of the source content"`
+	Operation  string   `json:"operation" valid:"member:image_thumbnail|video_thumbnail|video_preview|doc_thumbnail" desc:"operation applied to the source content"`
+	Params     []string `json:"params" valid:"notEmpty,maxLen:50" desc:"parameters of the operation"`
+}
+
+type SaveDerivativeReq struct {
+	SourceSha1   string `json:"sourceSha1" valid:"notEmpty" desc:"sha1 of the source content"`
+	SourceFileId string `json:"sourceFileId" valid:"notEmpty" desc:"file_id of the source file that the derivative is generated from"`
+	Operation    string `json:"operation" valid:"member:image_thumbnail|video_thumbnail|video_preview|doc_thumbnail" desc:"operation applied to the source content"`
+	Params       string `json:"params" valid:"maxLen:255" desc:"parameters of the operation"`
+	FileId       string `json:"fileId" valid:"notEmpty" desc:"file_id of the derived file"`
+}
+
+type SaveFileMetadataReq struct {
+	FileId   string       `json:"fileId" valid:"notEmpty" desc:"file_id of the image, video or audio"`
+	Metadata FileMetadata `json:"metadata" desc:"media metadata extracted from the file"`
+}
+
+// Media playlist or segment transcoded along with the HLS master playlist.
+type HlsFile struct {
+	Path   string `json:"path" valid:"notEmpty,maxLen:255" desc:"path of the file in the transcoded output, e.g., '720p/index.m3u8'"`
+	FileId string `json:"fileId" valid:"notEmpty" desc:"file_id of the media playlist or segment"`
+}
+
+type SaveHlsFilesReq struct {
+	PlaylistFileId string    `json:"playlistFileId" valid:"notEmpty" desc:"file_id of the HLS master playlist"`
+	Files          []HlsFile `json:"files" valid:"notEmpty" desc:"media playlists and segments referenced by the master playlist"`
+}
+
+type LinkFileReq struct {
+	FileId string `json:"fileId" valid:"notEmpty" desc:"file_id of the file that is linked to"`
+	Name   string `json:"name" desc:"name of the new file, name of the linked file is used by default"`
+}
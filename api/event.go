@@ -10,7 +10,7 @@ var (
 	// Reply api.ImageCompressReplyEvent when the processing succeeds.
 	GenImgThumbnailPipeline = rabbit.NewEventPipeline[ImgThumbnailTriggerEvent]("event.bus.fstore.image.compress.processing").
 				LogPayload().
-				MaxRetry(10).
+				MaxRetry(HammerMaxRetry).
 				Document("GenImgThumbnailPipeline", "Pipeline to trigger async image thumbnail generation, will reply api.ImageCompressReplyEvent when the processing succeeds.", "fstore")
 
 	// Pipeline to trigger async video thumbnail generation.
@@ -18,34 +18,173 @@ var (
 	// Reply api.GenVideoThumbnailReplyEvent when the processing succeeds.
 	GenVidThumbnailPipeline = rabbit.NewEventPipeline[VidThumbnailTriggerEvent]("event.bus.fstore.video.thumbnail.processing").
 				LogPayload().
-				MaxRetry(10).
+				MaxRetry(HammerMaxRetry).
 				Document("GenVidThumbnailPipeline", "Pipeline to trigger async video thumbnail generation, will reply api.GenVideoThumbnailReplyEvent when the processing succeeds.", "fstore")
+
+	// Pipeline to trigger async document (pdf or office documents) thumbnail generation.
+	//
+	// Reply api.GenDocThumbnailReplyEvent when the processing succeeds.
+	GenDocThumbnailPipeline = rabbit.NewEventPipeline[DocThumbnailTriggerEvent]("event.bus.fstore.document.thumbnail.processing").
+				LogPayload().
+				MaxRetry(HammerMaxRetry).
+				Document("GenDocThumbnailPipeline", "Pipeline to trigger async document thumbnail generation, will reply api.GenDocThumbnailReplyEvent when the processing succeeds.", "fstore")
+
+	// Pipeline to trigger async audio cover art extraction and waveform generation.
+	//
+	// Reply api.AudioPreviewReplyEvent when the processing completes, the status of the reply tells whether it succeeds.
+	GenAudioPreviewPipeline = rabbit.NewEventPipeline[AudioPreviewTriggerEvent]("event.bus.fstore.audio.preview.processing").
+				LogPayload().
+				MaxRetry(HammerMaxRetry).
+				Document("GenAudioPreviewPipeline", "Pipeline to trigger async audio cover art extraction and waveform generation, will reply api.AudioPreviewReplyEvent when the processing completes.", "fstore")
+
+	// Pipeline that receives hammer jobs that still fail after all the retries, the original event is included.
+	//
+	// mini-fstore doesn't consume the events, they are kept in the queue for investigation or manual redelivery.
+	HammerDeadLetterPipeline = rabbit.NewEventPipeline[HammerDeadLetterEvent]("event.bus.fstore.hammer.dead-letter").
+					LogPayload().
+					Document("HammerDeadLetterPipeline", "Pipeline that receives hammer jobs that still fail after all the retries.", "fstore")
+
+	// Pipeline to trigger async video transcoding to HLS.
+	//
+	// Reply api.TranscodeHlsReplyEvent when the processing completes, the status of the reply tells whether it succeeds.
+	TranscodeHlsPipeline = rabbit.NewEventPipeline[TranscodeHlsTriggerEvent]("event.bus.fstore.video.hls.processing").
+				LogPayload().
+				MaxRetry(TranscodeHlsMaxRetry).
+				Document("TranscodeHlsPipeline", "Pipeline to trigger async video transcoding to HLS, will reply api.TranscodeHlsReplyEvent when the processing completes.", "fstore")
+)
+
+const (
+	HammerMaxRetry       = 10 // max retry of the thumbnail and audio preview pipelines, the messages are dropped afterwards
+	TranscodeHlsMaxRetry = 3  // max retry of TranscodeHlsPipeline, the messages are dropped afterwards
 )
 
+const (
+	DefThumbnailPreset = "default" // name of the default thumbnail preset
+
+	ThumbnailStatusOk                = "OK"                 // thumbnails are generated
+	ThumbnailStatusFileNotFound      = "FILE_NOT_FOUND"     // the file is not found or deleted
+	ThumbnailStatusUnsupportedFormat = "UNSUPPORTED_FORMAT" // the file format is not supported, no thumbnail is generated
+	ThumbnailStatusProcessingFailed  = "PROCESSING_FAILED"  // the file is probably corrupted, retrying doesn't help
+	ThumbnailStatusRetryExhausted    = "RETRY_EXHAUSTED"    // retryable errors (e.g., database or storage) persisted after all the retries
+	ThumbnailStatusInvalidSize       = "INVALID_SIZE"       // none of the requested presets or sizes is valid, no thumbnail is generated
+	ThumbnailStatusFileTooLarge      = "FILE_TOO_LARGE"     // the file exceeds 'fstore.hammer.max-size', it's not downloaded by the remote hammer worker
+)
+
+// Target size of a thumbnail rendition.
+//
+// Width or Height can be zero, the aspect ratio is then preserved using the other one.
+type ThumbnailSize struct {
+	Name   string `desc:"name of the rendition"`
+	Width  int    `desc:"max width in pixels"`
+	Height int    `desc:"max height in pixels"`
+}
+
 // Event sent to hammer to trigger an vidoe thumbnail generation.
 type VidThumbnailTriggerEvent struct {
-	Identifier string `desc:"dentifier"`
-	FileId     string `desc:"file id from mini-fstore"`
-	ReplyTo    string `desc:"event bus that will receive event about the generated video thumbnail."`
+	Identifier string          `desc:"dentifier"`
+	FileId     string          `desc:"file id from mini-fstore"`
+	ReplyTo    string          `desc:"event bus that will receive event about the generated video thumbnail."`
+	Presets    []string        `desc:"names of thumbnail presets configured in 'fstore.thumbnail.presets'"`
+	Sizes      []ThumbnailSize `desc:"custom thumbnail sizes, the 'default' preset is used if both presets and sizes are empty"`
+	Seek       string          `desc:"offset of the frame, percentage of duration (e.g., '10%') or seconds (e.g., '3.5s'), 'fstore.thumbnail.video.seek' is used by default"`
+	Candidates int             `desc:"number of candidate frames evaluated, the least dark and most detailed one is chosen, 'fstore.thumbnail.video.candidates' is used by default"`
+	Preview    bool            `desc:"whether to generate an animated GIF preview of the video"`
 }
 
 // Event sent to hammer to trigger an image compression.
 type ImgThumbnailTriggerEvent struct {
-	Identifier string `desc:"identifier"`
-	FileId     string `desc:"file id from mini-fstore"`
-	ReplyTo    string `desc:"event bus that will receive event about the generated image thumbnail."`
+	Identifier string          `desc:"identifier"`
+	FileId     string          `desc:"file id from mini-fstore"`
+	ReplyTo    string          `desc:"event bus that will receive event about the generated image thumbnail."`
+	Presets    []string        `desc:"names of thumbnail presets configured in 'fstore.thumbnail.presets'"`
+	Sizes      []ThumbnailSize `desc:"custom thumbnail sizes, the 'default' preset is used if both presets and sizes are empty"`
+	Format     string          `desc:"output format: jpeg, png, gif or webp (always lossless, avif is not supported), 'fstore.thumbnail.format' or the original format is used by default"`
+	Quality    int             `desc:"jpeg quality (1-100), ignored by the other formats, 'fstore.thumbnail.jpeg-quality' is used by default"`
+}
+
+// Event sent to hammer to trigger document thumbnail generation, the first page of the document is rendered.
+type DocThumbnailTriggerEvent struct {
+	Identifier string          `desc:"identifier"`
+	FileId     string          `desc:"file id from mini-fstore"`
+	ReplyTo    string          `desc:"event bus that will receive event about the generated document thumbnail."`
+	Presets    []string        `desc:"names of thumbnail presets configured in 'fstore.thumbnail.presets'"`
+	Sizes      []ThumbnailSize `desc:"custom thumbnail sizes, the 'default' preset is used if both presets and sizes are empty"`
+	Format     string          `desc:"output format: jpeg, png, gif or webp (always lossless, avif is not supported), 'fstore.thumbnail.format' or png is used by default"`
+	Quality    int             `desc:"jpeg quality (1-100), ignored by the other formats, 'fstore.thumbnail.jpeg-quality' is used by default"`
 }
 
 // Event replied from hammer about the compressed image.
 type ImageCompressReplyEvent struct {
-	Identifier string // identifier
-	FileId     string // file id from mini-fstore
+	Identifier string            // identifier
+	FileId     string            // file id from mini-fstore, the first rendition generated
+	Renditions map[string]string // rendition name -> file id from mini-fstore
+	Status     string            // api.ThumbnailStatus*, FileId is empty if the status is not api.ThumbnailStatusOk
+	Error      string            // reason of the failure
 }
 
 // Event replied from hammer about the generated video thumbnail.
 type GenVideoThumbnailReplyEvent struct {
-	Identifier string // identifier
-	FileId     string // file id from mini-fstore
+	Identifier    string            // identifier
+	FileId        string            // file id from mini-fstore, the first rendition generated
+	Renditions    map[string]string // rendition name -> file id from mini-fstore
+	PreviewFileId string            // file id of the animated GIF preview, only present if requested
+	Status        string            // api.ThumbnailStatus*, FileId is empty if the status is not api.ThumbnailStatusOk
+	Error         string            // reason of the failure
+}
+
+// Event sent to hammer to trigger audio cover art extraction and waveform generation.
+type AudioPreviewTriggerEvent struct {
+	Identifier string `desc:"identifier"`
+	FileId     string `desc:"file id from mini-fstore"`
+	ReplyTo    string `desc:"event bus that will receive event about the extracted cover art and the generated waveform."`
+	Peaks      int    `desc:"number of peaks in the waveform, 'fstore.audio.waveform-peaks' is used by default"`
+}
+
+// Event replied from hammer about the extracted cover art and the generated waveform.
+type AudioPreviewReplyEvent struct {
+	Identifier     string // identifier
+	FileId         string // file id of the original audio
+	CoverArtFileId string // file id of the cover art (jpeg), empty if the audio doesn't have embedded cover art
+	WaveformFileId string // file id of the waveform json, see api.Waveform
+	Status         string // api.ThumbnailStatus*, CoverArtFileId and WaveformFileId are empty if the status is not api.ThumbnailStatusOk
+	Error          string // reason of the failure
+}
+
+// Event sent to hammer to trigger video transcoding to HLS.
+type TranscodeHlsTriggerEvent struct {
+	Identifier string   `desc:"identifier"`
+	FileId     string   `desc:"file id from mini-fstore"`
+	ReplyTo    string   `desc:"event bus that will receive event about the transcoded HLS playlist."`
+	Renditions []string `desc:"names of HLS renditions configured in 'fstore.hls.renditions', all renditions are transcoded by default"`
+}
+
+// Event replied from hammer about the transcoded HLS playlist.
+//
+// The playlist can be streamed through /file/hls using a file key generated for PlaylistFileId.
+type TranscodeHlsReplyEvent struct {
+	Identifier     string            // identifier
+	FileId         string            // file id of the original video
+	PlaylistFileId string            // file id of the master playlist
+	Renditions     map[string]string // rendition name -> file id of the media playlist
+	Status         string            // api.ThumbnailStatus*, PlaylistFileId is empty if the status is not api.ThumbnailStatusOk
+	Error          string            // reason of the failure
+}
+
+// Event replied from hammer about the generated document thumbnail.
+type GenDocThumbnailReplyEvent struct {
+	Identifier string            // identifier
+	FileId     string            // file id from mini-fstore, the first rendition generated
+	Renditions map[string]string // rendition name -> file id from mini-fstore
+	Status     string            // api.ThumbnailStatus*, FileId is empty if the status is not api.ThumbnailStatusOk
+	Error      string            // reason of the failure
+}
+
+// Event sent to api.HammerDeadLetterPipeline when a hammer job still fails after all the retries.
+type HammerDeadLetterEvent struct {
+	Pipeline string // name of the pipeline
+	Payload  string // json payload of the original event
+	Error    string // error of the last attempt
+	Attempts int    // number of attempts
 }
 
 type UnzipFileReplyEvent struct {
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	FileOpDownload = "download" // signed file key operation - download using /file/raw
+	FileOpStream   = "stream"   // signed file key operation - streaming using /file/stream and /file/hls
+)
+
+var (
+	ErrInvalidSignedFileKey = errors.New("invalid signed file key")
+	ErrSignedFileKeyExpired = errors.New("signed file key expired")
+	ErrFileOpNotAllowed     = errors.New("file operation not allowed")
+)
+
+// Key used to sign file keys, it must be one of the keys configured in 'fstore.signed-url.keys' of mini-fstore.
+type SigningKey struct {
+	Id     string
+	Secret string
+}
+
+type SignFileKeyReq struct {
+	FileId   string
+	Filename string        // the name that will be used when downloading the file, name of the file is used if empty
+	Ttl      time.Duration // how long the signed file key is valid
+	Ops      []string      // operations allowed (FileOp*), all operations are allowed if empty
+}
+
+// Claims of signed file key.
+type SignedFileClaims struct {
+	FileId   string   `json:"f"`
+	Filename string   `json:"n,omitempty"`
+	Exp      int64    `json:"e"` // expiry, unix timestamp in seconds
+	Ops      []string `json:"o"`
+	KeyId    string   `json:"k"`
+}
+
+// Check whether the operation is allowed.
+func (c SignedFileClaims) Allows(op string) bool {
+	return slices.Contains(c.Ops, op)
+}
+
+// Mint signed file key, which can be used in place of the temporary file key returned by GenTempFileKey.
+//
+// Signed file keys are verified by mini-fstore using the signing keys configured without any state, they
+// can't be revoked before expiry.
+func SignFileKey(key SigningKey, req SignFileKeyReq) (string, error) {
+	if key.Id == "" || key.Secret == "" {
+		return "", fmt.Errorf("signing key id and secret are required")
+	}
+	if req.FileId == "" {
+		return "", fmt.Errorf("fileId is required")
+	}
+	if req.Ttl <= 0 {
+		return "", fmt.Errorf("ttl must be positive")
+	}
+	ops := req.Ops
+	if len(ops) < 1 {
+		ops = []string{FileOpDownload, FileOpStream}
+	}
+	for _, op := range ops {
+		if op != FileOpDownload && op != FileOpStream {
+			return "", fmt.Errorf("illegal file operation: '%v'", op)
+		}
+	}
+
+	payload, err := json.Marshal(SignedFileClaims{
+		FileId:   req.FileId,
+		Filename: req.Filename,
+		Exp:      time.Now().Add(req.Ttl).Unix(),
+		Ops:      ops,
+		KeyId:    key.Id,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal signed file claims, %v", err)
+	}
+	p := base64.RawURLEncoding.EncodeToString(payload)
+	return p + "." + signFileKeyPayload(key.Secret, p), nil
+}
+
+// Mint signed file key and build the URL of the operation, e.g., for FileOpDownload, the URL is
+// "${baseUrl}/file/raw?key=${signedFileKey}".
+func SignFileUrl(baseUrl string, op string, key SigningKey, req SignFileKeyReq) (string, error) {
+	var path string
+	switch op {
+	case FileOpDownload:
+		path = "/file/raw"
+	case FileOpStream:
+		path = "/file/stream"
+	default:
+		return "", fmt.Errorf("illegal file operation: '%v'", op)
+	}
+	if len(req.Ops) > 0 && !slices.Contains(req.Ops, op) {
+		req.Ops = append(slices.Clone(req.Ops), op)
+	}
+	k, err := SignFileKey(key, req)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(baseUrl, "/") + path + "?key=" + url.QueryEscape(k), nil
+}
+
+// Check whether the file key is a signed file key.
+//
+// Temporary file keys are standard base64 encoded, '.' never appears.
+func IsSignedFileKey(fileKey string) bool {
+	return strings.Contains(fileKey, ".")
+}
+
+// Verify signed file key and parse the claims.
+//
+// secretOf looks up the secret of the signing key by id, signed file keys signed by unknown keys are rejected.
+func VerifySignedFileKey(fileKey string, secretOf func(keyId string) (string, bool)) (SignedFileClaims, error) {
+	var c SignedFileClaims
+	p, sig, ok := strings.Cut(fileKey, ".")
+	if !ok || p == "" || sig == "" {
+		return c, ErrInvalidSignedFileKey
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return c, ErrInvalidSignedFileKey
+	}
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return c, ErrInvalidSignedFileKey
+	}
+
+	secret, ok := secretOf(c.KeyId)
+	if !ok {
+		return c, fmt.Errorf("%w, unknown signing key: '%v'", ErrInvalidSignedFileKey, c.KeyId)
+	}
+	if !hmac.Equal([]byte(sig), []byte(signFileKeyPayload(secret, p))) {
+		return c, ErrInvalidSignedFileKey
+	}
+	if time.Now().Unix() >= c.Exp {
+		return c, ErrSignedFileKeyExpired
+	}
+	return c, nil
+}
+
+func signFileKeyPayload(secret string, payload string) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignFileKey(t *testing.T) {
+	keys := map[string]string{"k1": "secret1", "k2": "secret2"}
+	secretOf := func(id string) (string, bool) {
+		s, ok := keys[id]
+		return s, ok
+	}
+
+	k, err := SignFileKey(SigningKey{Id: "k2", Secret: "secret2"},
+		SignFileKeyReq{FileId: "file_123", Filename: "a.mp4", Ttl: time.Minute, Ops: []string{FileOpStream}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSignedFileKey(k) {
+		t.Fatalf("%v should be a signed file key", k)
+	}
+
+	c, err := VerifySignedFileKey(k, secretOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.FileId != "file_123" || c.Filename != "a.mp4" || c.KeyId != "k2" {
+		t.Fatalf("unexpected claims: %+v", c)
+	}
+	if !c.Allows(FileOpStream) || c.Allows(FileOpDownload) {
+		t.Fatalf("unexpected ops: %+v", c.Ops)
+	}
+
+	// tampered payload
+	p, sig, _ := strings.Cut(k, ".")
+	if _, err := VerifySignedFileKey(p+"x."+sig, secretOf); !errors.Is(err, ErrInvalidSignedFileKey) {
+		t.Fatalf("expected ErrInvalidSignedFileKey, got %v", err)
+	}
+
+	// rotated out
+	delete(keys, "k2")
+	if _, err := VerifySignedFileKey(k, secretOf); !errors.Is(err, ErrInvalidSignedFileKey) {
+		t.Fatalf("expected ErrInvalidSignedFileKey, got %v", err)
+	}
+
+	// signed with wrong secret
+	k, _ = SignFileKey(SigningKey{Id: "k1", Secret: "wrong"}, SignFileKeyReq{FileId: "file_123", Ttl: time.Minute})
+	if _, err := VerifySignedFileKey(k, secretOf); !errors.Is(err, ErrInvalidSignedFileKey) {
+		t.Fatalf("expected ErrInvalidSignedFileKey, got %v", err)
+	}
+
+	// expired
+	k, _ = SignFileKey(SigningKey{Id: "k1", Secret: "secret1"}, SignFileKeyReq{FileId: "file_123", Ttl: time.Nanosecond})
+	if _, err := VerifySignedFileKey(k, secretOf); !errors.Is(err, ErrSignedFileKeyExpired) {
+		t.Fatalf("expected ErrSignedFileKeyExpired, got %v", err)
+	}
+
+	// temporary file keys are never mistaken as signed ones
+	if IsSignedFileKey("0fR1H1O0t8xQZjPzbGz4lRx/bPacIg") {
+		t.Fatal("temporary file key is not a signed file key")
+	}
+}
+
+func TestSignFileUrl(t *testing.T) {
+	u, err := SignFileUrl("http://localhost:8084/", FileOpDownload, SigningKey{Id: "k1", Secret: "secret1"},
+		SignFileKeyReq{FileId: "file_123", Ttl: time.Minute, Ops: []string{FileOpStream}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(u, "http://localhost:8084/file/raw?key=") {
+		t.Fatalf("unexpected url: %v", u)
+	}
+	if _, err := SignFileUrl("http://localhost:8084", "upload", SigningKey{Id: "k1", Secret: "secret1"},
+		SignFileKeyReq{FileId: "file_123", Ttl: time.Minute}); err == nil {
+		t.Fatal("expected error for illegal operation")
+	}
+}
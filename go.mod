@@ -1,11 +1,15 @@
 module github.com/curtisnewbie/mini-fstore
 
-go 1.18
+go 1.22.2
 
 require (
+	github.com/HugoSmits86/nativewebp v1.2.1
 	github.com/curtisnewbie/miso v0.1.9-0.20240917075707-adeedeaef2e1
 	github.com/disintegration/gift v1.2.1
-	golang.org/x/image v0.13.0
+	github.com/prometheus/client_golang v1.4.0
+	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
+	golang.org/x/image v0.24.0
+	golang.org/x/sync v0.11.0
 	gorm.io/gorm v1.23.8
 )
 
@@ -50,7 +54,6 @@ require (
 	github.com/nxadm/tail v1.4.11 // indirect
 	github.com/pelletier/go-toml v1.9.5 // indirect
 	github.com/pelletier/go-toml/v2 v2.0.5 // indirect
-	github.com/prometheus/client_golang v1.4.0 // indirect
 	github.com/prometheus/client_model v0.2.0 // indirect
 	github.com/prometheus/common v0.9.1 // indirect
 	github.com/prometheus/procfs v0.0.8 // indirect
@@ -66,9 +69,8 @@ require (
 	github.com/ugorji/go/codec v1.2.7 // indirect
 	golang.org/x/crypto v0.23.0 // indirect
 	golang.org/x/net v0.25.0 // indirect
-	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
-	golang.org/x/text v0.16.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
 	google.golang.org/protobuf v1.30.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
@@ -7,10 +7,52 @@ const (
 	PropTrashDir                  = "fstore.trash.dir"                   // where files are dumped to
 	PropTempDir                   = "fstore.tmp.dir"                     // temp directory
 	PropPDelStrategy              = "fstore.pdelete.strategy"            // strategy used to 'physically' delete files
+	PropPDelGracePeriod           = "fstore.pdelete.grace-period"        // minutes files are kept after they are logically deleted before they can be removed physically
+	PropPDelCron                  = "fstore.pdelete.cron"                // cron of RemoveDeletedFilesTask, the task is not scheduled if it's empty
 	PropSanitizeStorageTaskDryRun = "task.sanitize-storage-task.dry-run" // Enable dry run for SanitizeStorageTask
+	PropPurgeTrashTaskDryRun      = "task.purge-trash-task.dry-run"      // Enable dry run for PurgeTrashTask
+	PropRepairBlobsDryRun         = "fstore.repair-blobs.dry-run"        // Enable dry run for RepairBlobs
+	PropTrashRetention            = "fstore.trash.retention"             // number of days files are kept in trash dir before they are purged, 0 disables purging
+	PropTrashPurgeCron            = "fstore.trash.purge-cron"            // cron of PurgeTrashTask
 	PropEnableFstoreBackup        = "fstore.backup.enabled"
 
 	PropBackupAuthSecret = "fstore.backup.secret"
+
+	PropSignedUrlKeys  = "fstore.signed-url.keys" // keys (id and secret) used to verify signed file keys
+	PropTrustedProxies = "fstore.trusted-proxies" // CIDRs of the trusted proxies, X-Forwarded-For and X-Real-IP are only respected when they are set by the trusted proxies
+
+	PropMode                = "fstore.mode"                 // components enabled in current process: all, api or hammer
+	PropHammerRemoteAccess  = "fstore.hammer.remote-access" // hammer fetches and stores files through the HTTP API instead of database and storage directory
+	PropHammerRemoteService = "fstore.hammer.api-service"   // name of the mini-fstore service that serves the HTTP API for hammer
+	PropHammerRemoteMaxSize = "fstore.hammer.max-size"      // max size (in mb) of the file downloaded by hammer through the HTTP API
+
+	PropThumbnailPresets     = "fstore.thumbnail.presets"      // named thumbnail sizes
+	PropThumbnailFormat      = "fstore.thumbnail.format"       // output format of image thumbnails
+	PropThumbnailJpegQuality = "fstore.thumbnail.jpeg-quality" // jpeg quality of image thumbnails
+
+	PropVideoThumbnailSeek       = "fstore.thumbnail.video.seek"       // offset of video thumbnail, percentage of duration (e.g., 10%) or seconds
+	PropVideoThumbnailCandidates = "fstore.thumbnail.video.candidates" // number of candidate frames evaluated for video thumbnail
+
+	PropHlsRenditions      = "fstore.hls.renditions"       // HLS renditions transcoded
+	PropHlsSegmentDuration = "fstore.hls.segment-duration" // duration (in seconds) of HLS segments
+
+	PropWaveformPeaks = "fstore.audio.waveform-peaks" // number of peaks in the generated audio waveform
+
+	PropDocPreviewPdfRenderer     = "fstore.doc-preview.pdf-renderer"     // command used to render pdf page (poppler's pdftoppm)
+	PropDocPreviewOfficeEnabled   = "fstore.doc-preview.office.enabled"   // enable preview of office documents
+	PropDocPreviewOfficeConverter = "fstore.doc-preview.office.converter" // command used to convert office documents to pdf (LibreOffice)
+
+	PropProcessTimeout          = "fstore.process.timeout"           // timeout (in seconds) of external processes, e.g., ffmpeg
+	PropProcessTranscodeTimeout = "fstore.process.transcode-timeout" // timeout (in seconds) of transcoding processes
+	PropProcessMaxConcurrency   = "fstore.process.max-concurrency"   // max number of external processes running concurrently
+
+	PropImageVariantDir            = "fstore.image-variant.dir"             // where on-the-fly transformed images are cached
+	PropImageVariantMaxSize        = "fstore.image-variant.max-size"        // max size (in mb) of the transformed images cached
+	PropImageVariantMaxConcurrency = "fstore.image-variant.max-concurrency" // max number of image variants rendered concurrently
+	PropImageMaxPixels             = "fstore.image.max-pixels"              // max number of pixels (width * height) of images decoded in process
+
+	PropExtractMetadataOnUpload = "fstore.metadata.extract-on-upload"     // extract image metadata when files are uploaded
+	PropProbeVideoOnUpload      = "fstore.metadata.probe-video-on-upload" // probe video metadata using ffprobe when files are uploaded
 )
 
 func init() {
@@ -17,6 +17,7 @@ import (
 	"github.com/curtisnewbie/miso/encoding"
 	"github.com/curtisnewbie/miso/middleware/mysql"
 	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/middleware/task"
 	"github.com/curtisnewbie/miso/miso"
 	"github.com/curtisnewbie/miso/util"
 	"gorm.io/gorm"
@@ -37,10 +38,11 @@ var (
 	ErrServerMaintenance = miso.NewErrf("Server in maintenance, please try again later").WithCode("SERVER_MAINTENANCE")
 	ErrFileNotFound      = miso.NewErrf("File is not found").WithCode(api.FileNotFound)
 	ErrFileDeleted       = miso.NewErrf("File has been deleted already").WithCode(api.FileDeleted)
+	ErrFileRemoved       = miso.NewErrf("File has been removed physically").WithCode(api.FileRemoved)
 	ErrUnknownError      = miso.NewErrf("Unknown error").WithCode(api.UnknownError)
 	ErrFileIdRequired    = miso.NewErrf("fileId is required").WithCode(api.InvalidRequest)
 	ErrFilenameRequired  = miso.NewErrf("filename is required").WithCode(api.InvalidRequest)
-	ErrNotZipFile        = miso.NewErrf("Not a zip file").WithCode(api.IllegalFormat)
+	ErrNotZipFile        = miso.NewErrf("Not a zip file").WithCode(api.NotZipFile)
 
 	fileIdExistCache = redis.NewRCache[string]("fstore:fileid:exist:v1:",
 		redis.RCacheConfig{
@@ -56,6 +58,8 @@ func init() {
 	miso.SetDefProp(config.PropStorageDir, "./storage")
 	miso.SetDefProp(config.PropTrashDir, "./trash")
 	miso.SetDefProp(config.PropPDelStrategy, PdelStrategyTrash)
+	miso.SetDefProp(config.PropPDelGracePeriod, 60)
+	miso.SetDefProp(config.PropPDelCron, "0 * * * *")
 	miso.SetDefProp(config.PropSanitizeStorageTaskDryRun, false)
 	miso.SetDefProp(config.PropTempDir, "/tmp")
 }
@@ -84,6 +88,7 @@ func ZeroByteRange() ByteRange {
 type CachedFile struct {
 	FileId string `json:"fileId"`
 	Name   string `json:"name"`
+	FileKeyOptions
 }
 
 type PDelFileOp interface {
@@ -150,6 +155,7 @@ type File struct {
 	UplTime    util.ETime  `json:"uplTime"`
 	LogDelTime *util.ETime `json:"logDelTime"`
 	PhyDelTime *util.ETime `json:"phyDelTime"`
+	TrashTime  *util.ETime `json:"trashTime"`
 }
 
 // Check whether current file is of zero value
@@ -294,24 +300,29 @@ This func reads property 'fstore.pdelete.strategy'.
 If strategy is 'direct', files are deleted directly. If strategy is 'trash' (default),
 files are moved to 'trash' directory, which is specified in property 'fstore.trash.dir'
 
-This func should only be used during server maintenance (no one can upload file).
+Only files that are logically deleted before the grace period ('fstore.pdelete.grace-period') are removed.
+Uploads are not blocked, each file is removed while holding its FileLockKey, which is also
+obtained when a new upload is symbolically linked to it.
 */
 func RemoveDeletedFiles(rail miso.Rail, db *gorm.DB) error {
-	ok, err := EnterMaintenance(rail)
-	if err != nil {
-		return err
-	}
-	if !ok {
-		return miso.NewErrf("Server is already in maintenance")
-	}
-	defer LeaveMaintenance(rail)
+	return RunMaintenanceJob(rail, db, JobRemoveDeletedFiles)
+}
 
+func removeDeletedFiles(rail miso.Rail, db *gorm.DB, p *JobProgress) error {
 	start := time.Now()
 	defer miso.TimeOp(rail, start, "BatchPhyDelFiles")
 
-	before := start.Add(-1 * time.Hour) // only delete files that are logically deleted 1 hour ago
+	// only delete files that are logically deleted before the grace period
+	before := start.Add(-miso.GetPropDur(config.PropPDelGracePeriod, time.Minute))
+	var total int
+	if err := db.Raw("select count(*) from file where status = ? and log_del_time <= ?", api.FileStatusLogicDel, before).
+		Scan(&total).Error; err != nil {
+		return fmt.Errorf("failed to count logically deleted files, %v", err)
+	}
+	p.SetTotal(total)
 	var minId int = 0
 	var l []PendingPhyDelFile
+	var err error
 	strat := miso.GetPropStr(config.PropPDelStrategy)
 	delFileOp := NewPDelFileOp(strat)
 
@@ -324,15 +335,37 @@ func RemoveDeletedFiles(rail miso.Rail, db *gorm.DB) error {
 		}
 
 		for _, f := range l {
+			if p.Cancelled() {
+				return ErrJobCancelled
+			}
 			if e := PhyDelFile(rail, db, f.FileId, delFileOp); e != nil {
 				rail.Errorf("Failed to PhyDelFile, strategy: %v, fileId: %s, %v", strat, f.FileId, e)
+				p.AddFailed(1)
 			}
+			p.AddProcessed(1)
 		}
 		minId = l[len(l)-1].Id
 		rail.Debugf("BatchPhyDelFiles, minId: %v", minId)
 	}
 }
 
+// Schedule RemoveDeletedFilesTask on the task master node, the task is not scheduled if 'fstore.pdelete.cron' is empty.
+func ScheduleRemoveDeletedFilesTask(rail miso.Rail) error {
+	cron := strings.TrimSpace(miso.GetPropStr(config.PropPDelCron))
+	if cron == "" {
+		rail.Info("RemoveDeletedFilesTask is disabled, files are only removed physically when it's triggered manually")
+		return nil
+	}
+	return task.ScheduleDistributedTask(miso.Job{
+		Name:       "RemoveDeletedFilesTask",
+		Cron:       cron,
+		LogJobExec: true,
+		Run: func(rail miso.Rail) error {
+			return RunMaintenanceJob(rail, mysql.GetMySQL(), JobRemoveDeletedFiles)
+		},
+	})
+}
+
 type PendingPhyDelFile struct {
 	Id     int
 	FileId string
@@ -387,34 +420,58 @@ func FastCheckFileExists(rail miso.Rail, fileId string) error {
 
 // Create random file key for the file
 func RandFileKey(rail miso.Rail, name string, fileId string) (string, error) {
+	return RandScopedFileKey(rail, name, fileId, FileKeyOptions{})
+}
+
+// Create random file key for the file, the usage of the key is restricted by the options.
+func RandScopedFileKey(rail miso.Rail, name string, fileId string, opts FileKeyOptions) (string, error) {
+	if err := opts.validate(); err != nil {
+		return "", err
+	}
 	fk := util.ERand(30)
 	err := FastCheckFileExists(rail, fileId)
 	if err != nil {
 		return "", err
 	}
 
-	sby, em := encoding.WriteJson(CachedFile{Name: name, FileId: fileId})
+	ttl, ok := opts.ttl(fileKeyTtl)
+	if !ok {
+		return "", ErrInvalidFileKeyOptions.WithInternalMsg("expireAt is in the past: %v", opts.ExpireAt)
+	}
+	sby, em := encoding.WriteJson(CachedFile{Name: name, FileId: fileId, FileKeyOptions: opts})
 	if em != nil {
 		return "", fmt.Errorf("failed to marshal to CachedFile, %v", em)
 	}
-	c := redis.GetRedis().Set("fstore:file:key:"+fk, string(sby), 30*time.Minute)
-	return fk, c.Err()
+	c := redis.GetRedis().Set(fileKeyPrefix+fk, string(sby), ttl)
+	if c.Err() != nil {
+		return "", c.Err()
+	}
+	indexFileKey(rail, fileId, fk)
+	return fk, nil
 }
 
-// Refresh file key's expiration
-func RefreshFileKeyExp(rail miso.Rail, fileKey string) error {
-	c := redis.GetRedis().Expire("fstore:file:key:"+fileKey, 30*time.Minute)
+// Refresh file key's expiration, the key never outlives the absolute expiry.
+func RefreshFileKeyExp(rail miso.Rail, fileKey string, cf CachedFile) error {
+	ttl, ok := cf.ttl(fileKeyTtl)
+	if !ok {
+		return ErrFileNotFound.WithInternalMsg("file key expired at %v", cf.ExpireAt)
+	}
+	c := redis.GetRedis().Expire(fileKeyPrefix+fileKey, ttl)
 	if c.Err() != nil {
 		rail.Warnf("Failed to refresh file key expiration, fileKey: %v, %v", fileKey, c.Err())
 		return fmt.Errorf("failed to refresh key expiration, %v", c.Err())
 	}
+	if cf.MaxUses > 0 {
+		redis.GetRedis().Expire(fileKeyUsesPrefix+fileKey, ttl)
+	}
+	extendFileKeyIndex(rail, cf.FileId)
 	return nil
 }
 
 // Resolve CachedFile for the given fileKey
 func ResolveFileKey(rail miso.Rail, fileKey string) (bool, CachedFile) {
 	var cf CachedFile
-	c := redis.GetRedis().Get("fstore:file:key:" + fileKey)
+	c := redis.GetRedis().Get(fileKeyPrefix + fileKey)
 	if c.Err() != nil {
 		if errors.Is(c.Err(), redis.Nil) {
 			rail.Infof("FileKey not found, %v", fileKey)
@@ -453,23 +510,30 @@ func adjustByteRange(br ByteRange, fileSize int64) (ByteRange, error) {
 	return br, nil
 }
 
-// Stream file by a generated random file key
-func StreamFileKey(rail miso.Rail, w http.ResponseWriter, fileKey string, br ByteRange) error {
+// Resolve CachedFile and the DFile for the given fileKey.
+//
+// ErrFileNotFound or ErrFileDeleted is returned if the file is not available.
+func ResolveDFileKey(rail miso.Rail, fileKey string) (CachedFile, DFile, error) {
 	ok, cachedFile := ResolveFileKey(rail, fileKey)
 	if !ok {
-		return ErrFileNotFound
+		return cachedFile, DFile{}, ErrFileNotFound
 	}
 
 	ff, err := findDFile(cachedFile.FileId)
 	if err != nil {
-		return ErrFileNotFound
+		return cachedFile, ff, ErrFileNotFound
 	}
 	if ff.IsDeleted() {
-		return ErrFileDeleted
+		return cachedFile, ff, ErrFileDeleted
 	}
+	return cachedFile, ff, nil
+}
 
-	if e := RefreshFileKeyExp(rail, fileKey); e != nil {
-		return e
+// Stream file by a generated random file key or a signed file key
+func StreamFileKey(rail miso.Rail, w http.ResponseWriter, r *http.Request, fileKey string, br ByteRange) error {
+	_, ff, err := ResolveFileAccess(rail, fileKey, NewFileAccess(r, api.FileOpStream))
+	if err != nil {
+		return err
 	}
 
 	var ea error
@@ -488,25 +552,15 @@ func StreamFileKey(rail miso.Rail, w http.ResponseWriter, fileKey string, br Byt
 	return TransferFile(rail, w, ff, br)
 }
 
-// Download file by a generated random file key
-func DownloadFileKey(rail miso.Rail, w http.ResponseWriter, fileKey string) error {
-	ok, cachedFile := ResolveFileKey(rail, fileKey)
-	if !ok {
-		return ErrFileNotFound
-	}
-
-	dname := cachedFile.Name
-	inclName := dname == ""
-
-	ff, err := findDFile(cachedFile.FileId)
+// Download file by a generated random file key or a signed file key
+func DownloadFileKey(rail miso.Rail, w http.ResponseWriter, r *http.Request, fileKey string) error {
+	cachedFile, ff, err := ResolveFileAccess(rail, fileKey, NewFileAccess(r, api.FileOpDownload))
 	if err != nil {
-		return ErrFileNotFound
-	}
-	if ff.IsDeleted() {
-		return ErrFileDeleted
+		return err
 	}
 
-	if inclName {
+	dname := cachedFile.Name
+	if dname == "" {
 		dname = ff.Name
 	}
 
@@ -635,22 +689,33 @@ func UploadFile(rail miso.Rail, rd io.Reader, filename string) (string, error) {
 		return "", fmt.Errorf("failed to find duplicate file, %v", err)
 	}
 
-	// same file is found, save the symbolic link to the previous file instead
-	link := ""
-	if duplicateFileId != "" {
-		os.Remove(target)
-		link = duplicateFileId
-	}
-
-	ecf := CreateFileRec(rail, CreateFile{
+	c := CreateFile{
 		FileId: fileId,
 		Name:   filename,
 		Size:   size,
 		Md5:    md5,
 		Sha1:   sha1,
-		Link:   link,
-	})
-	return fileId, ecf
+	}
+
+	// same file is found, save the symbolic link to the previous file instead
+	linked := false
+	if duplicateFileId != "" {
+		c.Link = duplicateFileId
+		if linked, err = CreateLinkedFileRec(rail, c); err != nil {
+			return fileId, err
+		}
+	}
+	if linked {
+		os.Remove(target)
+	} else {
+		c.Link = ""
+		if ecf := CreateFileRec(rail, c); ecf != nil {
+			return fileId, ecf
+		}
+	}
+
+	triggerMetadataExtraction(rail, fileId, filename)
+	return fileId, nil
 }
 
 type CreateFile struct {
@@ -682,19 +747,72 @@ func CreateFileRec(rail miso.Rail, c CreateFile) error {
 	return nil
 }
 
+// Create file record that is symbolically linked to c.Link.
+//
+// The link target is locked using FileLockKey, so that it's not removed physically by PhyDelFile in the meantime.
+// Returns false if the link target has been removed already, the content should be saved as a new file instead.
+func CreateLinkedFileRec(rail miso.Rail, c CreateFile) (bool, error) {
+	return redis.RLockRun(rail, FileLockKey(c.Link), func() (bool, error) {
+		f, err := FindFile(mysql.GetMySQL(), c.Link)
+		if err != nil {
+			return false, fmt.Errorf("failed to find link target, %v", err)
+		}
+		if f.IsZero() || f.Status == api.FileStatusPhysicDel {
+			rail.Infof("Link target %v has been removed, saving %v as a new file", c.Link, c.FileId)
+			return false, nil
+		}
+		return true, CreateFileRec(rail, c)
+	})
+}
+
+// Create a new file record named as name that shares the blob of fileId, the new record can be deleted independently.
+//
+// Returns the file_id of the new record, or empty string if fileId is not found, deleted or its blob has been removed.
+func LinkFile(rail miso.Rail, db *gorm.DB, fileId string, name string) (string, error) {
+	f, err := FindFile(db, fileId)
+	if err != nil {
+		return "", err
+	}
+	if f.IsZero() || f.IsDeleted() {
+		return "", nil
+	}
+	link := f.FileId
+	if f.Link != "" {
+		link = f.Link // symbolic links never form a chain
+	}
+	if name == "" {
+		name = f.Name
+	}
+	c := CreateFile{FileId: GenFileId(), Link: link, Name: name, Size: f.Size, Md5: f.Md5, Sha1: f.Sha1}
+	ok, err := CreateLinkedFileRec(rail, c)
+	if err != nil || !ok {
+		return "", err
+	}
+	rail.Infof("Linked file %v to %v, blob: %v", c.FileId, fileId, link)
+	return c.FileId, nil
+}
+
+// Find file with the same content, returns file_id of the file that owns the blob, i.e., if the duplicate file is
+// a symbolic file, the file it links to is returned, so that symbolic links never form a chain.
 func FindDuplicateFile(rail miso.Rail, db *gorm.DB, size int64, sha1 string) (string, error) {
-	var fileId string
+	var dup struct {
+		FileId string
+		Link   string
+	}
 	t := db.Table("file").
-		Select("file_id").
+		Select("file_id, link").
 		Where("sha1 = ?", sha1).
 		Where("status in (?, ?)", api.FileStatusNormal, api.FileStatusLogicDel).
 		Where("size = ?", size).
 		Limit(1).
-		Scan(&fileId)
+		Scan(&dup)
 	if t.Error != nil {
 		return "", fmt.Errorf("failed to query duplicate file in db, %v", t.Error)
 	}
-	return fileId, nil
+	if dup.Link != "" {
+		return dup.Link, nil
+	}
+	return dup.FileId, nil
 }
 
 func CheckFileExists(fileId string) (bool, error) {
@@ -726,12 +844,30 @@ func FindFile(db *gorm.DB, fileId string) (File, error) {
 	return f, nil
 }
 
+// Find files in one query, returns fileId -> File, files not found are absent.
+func FindFiles(db *gorm.DB, fileIds []string) (map[string]File, error) {
+	found := make(map[string]File, len(fileIds))
+	if len(fileIds) < 1 {
+		return found, nil
+	}
+	var l []File
+	t := db.Raw("select * from file where file_id in ?", fileIds).Scan(&l)
+	if t.Error != nil {
+		return nil, fmt.Errorf("failed to select files from DB, %w", t.Error)
+	}
+	for _, f := range l {
+		found[f.FileId] = f
+	}
+	return found, nil
+}
+
 type DFile struct {
 	FileId string
 	Link   string
 	Size   int64
 	Status string
 	Name   string
+	Sha1   string
 }
 
 // Check if the file is deleted already
@@ -755,7 +891,7 @@ func (f *DFile) StoragePath() string {
 func findDFile(fileId string) (DFile, error) {
 	var df DFile
 	t := mysql.GetMySQL().
-		Select("file_id, size, status, name, link").
+		Select("file_id, size, status, name, link, sha1").
 		Table("file").
 		Where("file_id = ?", fileId).
 		Scan(&df)
@@ -799,9 +935,110 @@ func LDelFile(rail miso.Rail, db *gorm.DB, fileId string) error {
 	if t.Error != nil {
 		return ErrUnknownError.WithInternalMsg("Failed to update file, %v", t.Error)
 	}
+
+	if err := fileIdExistCache.Del(rail, fileId); err != nil {
+		rail.Warnf("Failed to evict file existence cache, fileId: %v, %v", fileId, err)
+	}
+
+	// the file is no longer accessible anyway, the keys are revoked to release them early
+	if err := RevokeFileKeys(rail, fileId); err != nil {
+		rail.Warnf("Failed to revoke file keys of deleted file, fileId: %v, %v", fileId, err)
+	}
 	return nil
 }
 
+// Undelete (restore) the logically deleted file, it's only possible before the file is removed physically.
+//
+// Undeleting a file that is not deleted is a no-op.
+func UndeleteFile(rail miso.Rail, db *gorm.DB, fileId string) error {
+	fileId = strings.TrimSpace(fileId)
+	if fileId == "" {
+		return ErrFileIdRequired
+	}
+
+	lock := redis.NewRLock(rail, FileLockKey(fileId))
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	f, er := FindFile(db, fileId)
+	if er != nil {
+		return ErrUnknownError.WithInternalMsg("FindFile failed, %v", er)
+	}
+	if f.IsZero() {
+		return ErrFileNotFound
+	}
+	if !f.IsDeleted() {
+		return nil
+	}
+	if !f.IsLogiDeleted() {
+		return ErrFileRemoved
+	}
+
+	// the content is shared with the link target, the target may have been removed already
+	if f.Link != "" {
+		target, err := FindFile(db, f.Link)
+		if err != nil {
+			return ErrUnknownError.WithInternalMsg("FindFile failed, %v", err)
+		}
+		if target.IsZero() || target.Status == api.FileStatusPhysicDel {
+			return ErrFileRemoved.WithInternalMsg("link target %v of %v has been removed", f.Link, fileId)
+		}
+	}
+
+	t := db.Exec("update file set status = ?, log_del_time = NULL where file_id = ? and status = ?",
+		api.FileStatusNormal, fileId, api.FileStatusLogicDel)
+	if t.Error != nil {
+		return ErrUnknownError.WithInternalMsg("Failed to update file, %v", t.Error)
+	}
+	if err := fileIdExistCache.Del(rail, fileId); err != nil {
+		rail.Warnf("Failed to evict file existence cache, fileId: %v, %v", fileId, err)
+	}
+	rail.Infof("Undeleted file %v", fileId)
+	return nil
+}
+
+// Result of operation on one of the files in batch.
+func fileOpResult(fileId string, err error) api.FileOpResult {
+	r := api.FileOpResult{FileId: fileId, Ok: err == nil}
+	if err != nil {
+		var me *miso.MisoErr
+		if errors.As(err, &me) {
+			r.ErrCode, r.Error = me.Code, me.Msg
+		} else {
+			r.ErrCode, r.Error = api.UnknownError, ErrUnknownError.Msg
+		}
+	}
+	return r
+}
+
+// Run the operation on each of the files in batch, returns result of each file in order.
+func batchFileOp(rail miso.Rail, fileIds []string, op func(fileId string) error) ([]api.FileOpResult, error) {
+	if len(fileIds) > MaxBatchSize {
+		return nil, ErrBatchTooLarge.WithInternalMsg("batch size: %v", len(fileIds))
+	}
+	results := make([]api.FileOpResult, 0, len(fileIds))
+	for _, fileId := range fileIds {
+		err := op(fileId)
+		if err != nil {
+			rail.Warnf("Batch operation failed on file %v, %v", fileId, err)
+		}
+		results = append(results, fileOpResult(fileId, err))
+	}
+	return results, nil
+}
+
+// Logically delete files in batch, returns result of each file in order.
+func LDelFiles(rail miso.Rail, db *gorm.DB, fileIds []string) ([]api.FileOpResult, error) {
+	return batchFileOp(rail, fileIds, func(fileId string) error { return LDelFile(rail, db, fileId) })
+}
+
+// Undelete files in batch, returns result of each file in order.
+func UndeleteFiles(rail miso.Rail, db *gorm.DB, fileIds []string) ([]api.FileOpResult, error) {
+	return batchFileOp(rail, fileIds, func(fileId string) error { return UndeleteFile(rail, db, fileId) })
+}
+
 // List logically deleted files
 func ListLDelFile(rail miso.Rail, idOffset int64, limit int) ([]File, error) {
 	var l []File = []File{}
@@ -838,30 +1075,42 @@ func PhyDelFile(rail miso.Rail, db *gorm.DB, fileId string, op PDelFileOp) error
 			return nil, nil
 		}
 
-		// the file may be pointed by another symbolic file
-		// before we delete it, we need to make sure that it's not pointed
-		// by other files
-		var refId int
-		if err := mysql.GetMySQL().
-			Raw("select id from file where link = ? and status = ? limit 1", f.FileId, api.FileStatusNormal).
-			Scan(&refId).Error; err != nil {
-			return nil, fmt.Errorf("failed to check symbolic link, fileId: %v, %v", f.FileId, err)
-		}
-		if refId > 0 { // link exists, we cannot really delete it
-			rail.Infof("File %v is still symbolically linked by other files, cannot be removed yet", fileId)
-			return nil, nil
+		// the blob may be shared with symbolic files (which don't have blobs of their own), it's only reclaimed
+		// once none of them is NORMAL or LOG_DEL (can still be undeleted), the file is left LOG_DEL until then
+		// and is revisited by RemoveDeletedFiles, so the blob is reclaimed regardless of which one is deleted first
+		if f.Link == "" {
+			linked, err := isBlobLinked(db, f.FileId)
+			if err != nil {
+				return nil, err
+			}
+			if linked {
+				rail.Infof("File %v is still symbolically linked by other files, cannot be removed yet", fileId)
+				return nil, nil
+			}
+			if ed := op.delete(rail, fileId); ed != nil {
+				return nil, ed
+			}
 		}
 
-		if ed := op.delete(rail, fileId); ed != nil {
-			return nil, ed
+		// trash_time is tracked for PurgeTrash, symbolic files don't have anything moved to trash
+		now := time.Now()
+		var trashTime *time.Time
+		if _, ok := op.(PDelFileTrashOp); ok && f.Link == "" {
+			trashTime = &now
 		}
-
 		t := mysql.GetMySQL().
-			Exec("update file set status = ?, phy_del_time = ? where file_id = ?", api.FileStatusPhysicDel, time.Now(), fileId)
+			Exec("update file set status = ?, phy_del_time = ?, trash_time = ? where file_id = ?", api.FileStatusPhysicDel, now, trashTime, fileId)
 		if t.Error != nil {
 			return nil, ErrUnknownError.WithInternalMsg("Failed to update file, %v", t.Error)
 		}
 
+		// derived files are useless once the content is gone
+		if f.Link == "" {
+			if err := removeDerivatives(rail, db, f.Sha1); err != nil {
+				rail.Errorf("Failed to remove derived files, fileId: %v, %v", fileId, err)
+			}
+		}
+
 		return nil, nil
 	})
 	return e
@@ -873,6 +1122,10 @@ func FileLockKey(fileId string) string {
 }
 
 func SanitizeStorage(rail miso.Rail) error {
+	return sanitizeStorage(rail, nil)
+}
+
+func sanitizeStorage(rail miso.Rail, p *JobProgress) error {
 	dirPath := miso.GetPropStr(config.PropStorageDir)
 	files, e := os.ReadDir(dirPath)
 	if e != nil {
@@ -886,8 +1139,14 @@ func SanitizeStorage(rail miso.Rail) error {
 	}
 
 	rail.Infof("Found %v files", len(files))
+	p.SetTotal(len(files))
 	threshold := time.Now().Add(-6 * time.Hour)
 	for _, f := range files {
+		if p.Cancelled() {
+			return ErrJobCancelled
+		}
+		p.AddProcessed(1)
+
 		fi, e := f.Info()
 		if e != nil {
 			return fmt.Errorf("failed to read file info, %v", e)
@@ -922,6 +1181,12 @@ func SanitizeStorage(rail miso.Rail) error {
 					continue
 				}
 				rail.Errorf("Sanitizing storage, failed to rename file from %s to %s, %v", frm, to, e)
+				p.AddFailed(1)
+				continue
+			}
+			// the file is not tracked in database, the modification time is used as the time it's trashed by PurgeTrash
+			if e := os.Chtimes(to, time.Now(), time.Now()); e != nil {
+				rail.Warnf("Sanitizing storage, failed to update modification time of %s, %v", to, e)
 			}
 			rail.Infof("Sanitizing storage, renamed file from %s to %s", frm, to)
 		}
@@ -1079,32 +1344,39 @@ func SaveZipFile(rail miso.Rail, db *gorm.DB, entry UnpackedZipEntry) (SavedZipE
 	}
 
 	fileId := GenFileId()
-	link := ""
+	c := CreateFile{
+		FileId: fileId,
+		Name:   entry.Name,
+		Size:   entry.Size,
+		Md5:    entry.Md5,
+		Sha1:   entry.Sha1,
+	}
+	linked := false
 	if duplicateFileId != "" {
 		// same file is found, save the symbolic link to the previous file instead
 		// the temporary entry file will be removed anyway
-		link = duplicateFileId
-		rail.Infof("Found duplicate upload, create symbolic link for %v to %v", entry.Name, duplicateFileId)
-	} else {
+		c.Link = duplicateFileId
+		if linked, err = CreateLinkedFileRec(rail, c); err != nil {
+			return SavedZipEntry{}, fmt.Errorf("failled to create file record for zip entry, %v", err)
+		}
+		if linked {
+			rail.Infof("Found duplicate upload, created symbolic link for %v to %v", entry.Name, duplicateFileId)
+		}
+	}
+	if !linked {
 		// file is not found, move the zip entry file to the storage directory
 		storagePath := GenStoragePath(fileId)
 		err := os.Rename(entry.Path, storagePath)
 		if err != nil {
 			return SavedZipEntry{}, fmt.Errorf("failed to move zip entry file from %v to %v, %v", entry.Path, storagePath, err)
 		}
-	}
 
-	err = CreateFileRec(rail, CreateFile{
-		FileId: fileId,
-		Name:   entry.Name,
-		Size:   entry.Size,
-		Md5:    entry.Md5,
-		Sha1:   entry.Sha1,
-		Link:   link,
-	})
-	if err != nil {
-		return SavedZipEntry{}, fmt.Errorf("failled to create file record for zip entry, %v", err)
+		c.Link = ""
+		if err = CreateFileRec(rail, c); err != nil {
+			return SavedZipEntry{}, fmt.Errorf("failled to create file record for zip entry, %v", err)
+		}
 	}
+	triggerMetadataExtraction(rail, fileId, entry.Name)
 
 	return SavedZipEntry{
 		Md5:    entry.Md5,
@@ -1115,13 +1387,10 @@ func SaveZipFile(rail miso.Rail, db *gorm.DB, entry UnpackedZipEntry) (SavedZipE
 }
 
 func ComputeFilesChecksum(rail miso.Rail, db *gorm.DB) error {
-	lock := redis.NewCustomRLock(rail, "mini-fstore:maintenance:compute-checksum",
-		redis.RLockConfig{BackoffDuration: 1 * time.Second})
-	if err := lock.Lock(); err != nil {
-		return fmt.Errorf("ComputeFilesChecksum() is running, please try later")
-	}
-	defer lock.Unlock()
+	return RunMaintenanceJob(rail, db, JobComputeChecksum)
+}
 
+func computeFilesChecksum(rail miso.Rail, db *gorm.DB, p *JobProgress) error {
 	rail.Info("Running ComputeFilesChecksum maintainance operation")
 
 	type ComputingFile struct {
@@ -1153,8 +1422,13 @@ func ComputeFilesChecksum(rail miso.Rail, db *gorm.DB) error {
 		lastId = files[len(files)-1].Id
 
 		for _, f := range files {
-			p := FileStoragePath(f.FileId, f.Link)
-			sha1, err := ChkSumSha1(p)
+			if p.Cancelled() {
+				return ErrJobCancelled
+			}
+			p.AddProcessed(1)
+
+			path := FileStoragePath(f.FileId, f.Link)
+			sha1, err := ChkSumSha1(path)
 			if err == nil && sha1 != "" {
 				if er := db.Exec(`UPDATE file set sha1 = ? WHERE id = ?`, sha1, f.Id).Error; er != nil {
 					return fmt.Errorf("failed to update file sha1 checksum, id: %v, %v", f.Id, err)
@@ -1162,7 +1436,8 @@ func ComputeFilesChecksum(rail miso.Rail, db *gorm.DB) error {
 					rail.Infof("Updated sha1: %v to id: %v, fileId: %v", sha1, f.Id, f.FileId)
 				}
 			} else {
-				rail.Errorf("Failed to generate sha1 checksum, %#v, path: %v, %v", f, p, err)
+				rail.Errorf("Failed to generate sha1 checksum, %#v, path: %v, %v", f, path, err)
+				p.AddFailed(1)
 			}
 		}
 	}
@@ -0,0 +1,225 @@
+package fstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/miso"
+	"gorm.io/gorm"
+)
+
+const (
+	repairBlobsBatch = 500
+)
+
+func init() {
+	miso.SetDefProp(config.PropRepairBlobsDryRun, false)
+}
+
+// Check whether the blob owned by the file is still used by symbolic files that are not removed physically.
+func isBlobLinked(db *gorm.DB, fileId string) (bool, error) {
+	var refId int
+	if err := db.Raw("select id from file where link = ? and status != ? limit 1", fileId, api.FileStatusPhysicDel).
+		Scan(&refId).Error; err != nil {
+		return false, fmt.Errorf("failed to check symbolic link, fileId: %v, %v", fileId, err)
+	}
+	return refId > 0, nil
+}
+
+type RepairBlobsResult struct {
+	DryRun      bool `json:"dryRun" desc:"whether it's a dry-run, nothing is changed"`
+	Rescheduled int  `json:"rescheduled" desc:"number of logically deleted files without log_del_time, they are never removed physically"`
+	Relinked    int  `json:"relinked" desc:"number of symbolic files that are linked to another symbolic file, they are relinked to the file owning the blob"`
+	Recovered   int  `json:"recovered" desc:"number of removed files that are still linked, their blobs are brought back from trash"`
+	Broken      int  `json:"broken" desc:"number of removed files that are still linked, but the blobs can't be recovered"`
+	Reclaimed   int  `json:"reclaimed" desc:"number of blobs left in storage dir that are no longer used by any file"`
+}
+
+/*
+Repair blobs that are shared by symbolic files (deduplicated) but are left in an inconsistent state by previous
+versions of mini-fstore:
+
+  - logically deleted files without log_del_time are rescheduled for physical deletion.
+  - symbolic files linked to another symbolic file are relinked to the file owning the blob.
+  - files removed physically while they are still linked are marked logically deleted, and the blobs are brought back from trash.
+  - blobs left in storage dir that are no longer used by any file are removed based on 'fstore.pdelete.strategy'.
+
+If 'fstore.repair-blobs.dry-run' is enabled, the inconsistencies are only counted and logged.
+*/
+func RepairBlobs(rail miso.Rail, db *gorm.DB) error {
+	return RunMaintenanceJob(rail, db, JobRepairBlobs)
+}
+
+func repairBlobs(rail miso.Rail, db *gorm.DB, p *JobProgress) error {
+	defer miso.TimeOp(rail, time.Now(), "RepairBlobs")
+
+	res := RepairBlobsResult{DryRun: miso.GetPropBool(config.PropRepairBlobsDryRun)}
+	steps := []func(rail miso.Rail, db *gorm.DB, res *RepairBlobsResult) error{
+		rescheduleLogDelFiles, flattenLinkChains, recoverLinkedBlobs, reclaimOrphanedBlobs,
+	}
+	p.SetTotal(len(steps))
+	for _, step := range steps {
+		if p.Cancelled() {
+			return ErrJobCancelled
+		}
+		if err := step(rail, db, &res); err != nil {
+			return err
+		}
+		p.AddProcessed(1)
+	}
+	p.AddFailed(res.Broken)
+	rail.Infof("Repaired blobs, result: %+v", res)
+	return nil
+}
+
+func rescheduleLogDelFiles(rail miso.Rail, db *gorm.DB, res *RepairBlobsResult) error {
+	if res.DryRun {
+		var cnt int
+		if err := db.Raw("select count(*) from file where status = ? and log_del_time is null", api.FileStatusLogicDel).
+			Scan(&cnt).Error; err != nil {
+			return fmt.Errorf("failed to count logically deleted files, %v", err)
+		}
+		res.Rescheduled = cnt
+		return nil
+	}
+	t := db.Exec("update file set log_del_time = ? where status = ? and log_del_time is null", time.Now(), api.FileStatusLogicDel)
+	if t.Error != nil {
+		return fmt.Errorf("failed to update logically deleted files, %v", t.Error)
+	}
+	res.Rescheduled = int(t.RowsAffected)
+	return nil
+}
+
+func flattenLinkChains(rail miso.Rail, db *gorm.DB, res *RepairBlobsResult) error {
+	type chainedFile struct {
+		FileId string
+		Root   string
+	}
+	for {
+		var l []chainedFile
+		if err := db.Raw(`select f.file_id, t.link root from file f join file t on f.link = t.file_id
+			where t.link != '' limit ?`, repairBlobsBatch).Scan(&l).Error; err != nil {
+			return fmt.Errorf("failed to list chained symbolic files, %v", err)
+		}
+		if len(l) < 1 {
+			return nil
+		}
+		for _, f := range l {
+			if res.DryRun {
+				rail.Infof("Repairing blobs, (dry-run) will relink %v to %v", f.FileId, f.Root)
+				continue
+			}
+			if err := db.Exec("update file set link = ? where file_id = ?", f.Root, f.FileId).Error; err != nil {
+				return fmt.Errorf("failed to relink file %v, %v", f.FileId, err)
+			}
+			rail.Infof("Repairing blobs, relinked %v to %v", f.FileId, f.Root)
+		}
+		res.Relinked += len(l)
+		if res.DryRun {
+			return nil // nothing is changed, the same files are listed again
+		}
+	}
+}
+
+func recoverLinkedBlobs(rail miso.Rail, db *gorm.DB, res *RepairBlobsResult) error {
+	var fileIds []string
+	if err := db.Raw(`select distinct t.file_id from file t join file f on f.link = t.file_id
+		where t.status = ? and f.status != ?`, api.FileStatusPhysicDel, api.FileStatusPhysicDel).
+		Scan(&fileIds).Error; err != nil {
+		return fmt.Errorf("failed to list removed files that are still linked, %v", err)
+	}
+	for _, fileId := range fileIds {
+		if res.DryRun {
+			rail.Infof("Repairing blobs, (dry-run) will recover blob of %v", fileId)
+			res.Recovered++
+			continue
+		}
+		if err := restoreLinkTarget(rail, db, fileId); err != nil {
+			if errors.Is(err, ErrFileRemoved) {
+				rail.Errorf("Repairing blobs, blob of %v is lost, the files linked to it are broken, %v", fileId, err)
+				res.Broken++
+				continue
+			}
+			return fmt.Errorf("failed to recover blob of %v, %w", fileId, err)
+		}
+		res.Recovered++
+	}
+	return nil
+}
+
+func reclaimOrphanedBlobs(rail miso.Rail, db *gorm.DB, res *RepairBlobsResult) error {
+	entries, err := os.ReadDir(miso.GetPropStr(config.PropStorageDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read dir, %v", err)
+	}
+	op := NewPDelFileOp(miso.GetPropStr(config.PropPDelStrategy))
+
+	for len(entries) > 0 {
+		n := min(repairBlobsBatch, len(entries))
+		batch := entries[:n]
+		entries = entries[n:]
+
+		fileIds := make([]string, 0, len(batch))
+		for _, e := range batch {
+			if !e.IsDir() {
+				fileIds = append(fileIds, e.Name())
+			}
+		}
+
+		// files without record are handled by SanitizeStorage
+		var removed []string
+		if err := db.Raw("select file_id from file where file_id in ? and link = '' and status = ?", fileIds, api.FileStatusPhysicDel).
+			Scan(&removed).Error; err != nil {
+			return fmt.Errorf("failed to select removed files, %v", err)
+		}
+		for _, fileId := range removed {
+			ok, err := reclaimOrphanedBlob(rail, db, fileId, op, res.DryRun)
+			if err != nil {
+				rail.Errorf("Repairing blobs, failed to reclaim blob of %v, %v", fileId, err)
+				continue
+			}
+			if ok {
+				res.Reclaimed++
+			}
+		}
+	}
+	return nil
+}
+
+func reclaimOrphanedBlob(rail miso.Rail, db *gorm.DB, fileId string, op PDelFileOp, dryRun bool) (bool, error) {
+	return redis.RLockRun(rail, FileLockKey(fileId), func() (bool, error) {
+		f, err := FindFile(db, fileId)
+		if err != nil {
+			return false, err
+		}
+		if f.Status != api.FileStatusPhysicDel {
+			return false, nil
+		}
+		linked, err := isBlobLinked(db, fileId)
+		if err != nil || linked {
+			return false, err
+		}
+		if dryRun {
+			rail.Infof("Repairing blobs, (dry-run) will reclaim orphaned blob of %v", fileId)
+			return true, nil
+		}
+		if err := op.delete(rail, fileId); err != nil {
+			return false, err
+		}
+		if _, ok := op.(PDelFileTrashOp); ok {
+			if err := db.Exec("update file set trash_time = ? where file_id = ?", time.Now(), fileId).Error; err != nil {
+				rail.Warnf("Failed to update trash_time of %v, %v", fileId, err)
+			}
+		}
+		rail.Infof("Repairing blobs, reclaimed orphaned blob of %v", fileId)
+		return true, nil
+	})
+}
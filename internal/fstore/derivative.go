@@ -0,0 +1,200 @@
+package fstore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/miso/miso"
+	"gorm.io/gorm"
+)
+
+const (
+	DerivImageThumbnail = "image_thumbnail" // derivative operation - image thumbnail
+	DerivVideoThumbnail = "video_thumbnail" // derivative operation - video thumbnail
+	DerivVideoPreview   = "video_preview"   // derivative operation - animated video preview
+	DerivDocThumbnail   = "doc_thumbnail"   // derivative operation - document thumbnail
+	DerivHls            = "hls"             // derivative operation - media playlist or segment of HLS master playlist
+)
+
+// Derived file generated from the content of the source file, e.g., thumbnails.
+type Derivative struct {
+	SourceSha1   string `json:"sourceSha1"`   // sha1 of the source content
+	SourceFileId string `json:"sourceFileId"` // file id of the source file that the derivative is generated from
+	Operation    string `json:"operation"`    // operation applied
+	Params       string `json:"params"`       // parameters of the operation
+	FileId       string `json:"fileId"`       // file id of the derived file
+}
+
+var (
+	ErrSourceSha1Mismatch = miso.NewErrf("sha1 of the source file doesn't match").WithCode(api.InvalidRequest)
+	ErrNotHlsPlaylist     = miso.NewErrf("File is not a HLS playlist").WithCode(api.NotHlsPlaylist)
+)
+
+// Find derived files generated from the same content using the same operation and parameters.
+//
+// Returns params -> file id of the derived file, derived files that are deleted are excluded.
+func FindDerivatives(rail miso.Rail, db *gorm.DB, sourceSha1 string, operation string, params []string) (map[string]string, error) {
+	found := map[string]string{}
+	if sourceSha1 == "" || len(params) < 1 {
+		return found, nil
+	}
+
+	var l []Derivative
+	err := db.Raw(`
+		SELECT d.params, d.file_id FROM file_derivative d
+		LEFT JOIN file f ON d.file_id = f.file_id
+		WHERE d.source_sha1 = ? AND d.operation = ? AND d.params IN ? AND f.status = ?
+	`, sourceSha1, operation, params, api.FileStatusNormal).Scan(&l).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to select file derivatives, sha1: %v, %v", sourceSha1, err)
+	}
+	for _, d := range l {
+		found[d.Params] = d.FileId
+	}
+	return found, nil
+}
+
+// Check that both the source file and the derived file exist, and sourceSha1 is the sha1 of the source file.
+func CheckDerivative(db *gorm.DB, d Derivative) error {
+	files, err := FindFiles(db, []string{d.SourceFileId, d.FileId})
+	if err != nil {
+		return err
+	}
+	for _, fileId := range []string{d.SourceFileId, d.FileId} {
+		f, ok := files[fileId]
+		if !ok {
+			return ErrFileNotFound.WithInternalMsg("file not found, fileId: %v", fileId)
+		}
+		if f.IsDeleted() {
+			return ErrFileDeleted.WithInternalMsg("file deleted, fileId: %v", fileId)
+		}
+	}
+	if src := files[d.SourceFileId]; src.Sha1 != d.SourceSha1 {
+		return ErrSourceSha1Mismatch.WithInternalMsg("source fileId: %v, sha1: %v, expected: %v", d.SourceFileId, d.SourceSha1, src.Sha1)
+	}
+	return nil
+}
+
+// Save derived file, existing one is overwritten.
+func SaveDerivative(rail miso.Rail, db *gorm.DB, d Derivative) error {
+	if d.SourceSha1 == "" {
+		return nil // checksum not computed yet, can't be reused anyway
+	}
+	err := db.Exec(`
+		INSERT INTO file_derivative (source_sha1, source_file_id, operation, params, file_id)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE source_file_id = VALUES(source_file_id), file_id = VALUES(file_id)
+	`, d.SourceSha1, d.SourceFileId, d.Operation, d.Params, d.FileId).Error
+	if err != nil {
+		return fmt.Errorf("failed to save file derivative, %+v, %v", d, err)
+	}
+	rail.Debugf("Saved file derivative: %+v", d)
+	return nil
+}
+
+// Register the media playlists and segments transcoded along with the HLS master playlist.
+//
+// Each file is saved as a derivative of the master playlist (operation DerivHls), params is the path of the file
+// in the transcoded output, e.g., '720p/index.m3u8' or '720p/seg_00000.ts'. Only the registered files are served
+// by /file/hls, playlists uploaded by the users are never trusted.
+func SaveHlsFiles(rail miso.Rail, db *gorm.DB, playlistFileId string, files []api.HlsFile) error {
+	fileIds := make([]string, 0, len(files)+1)
+	fileIds = append(fileIds, playlistFileId)
+	for _, f := range files {
+		fileIds = append(fileIds, f.FileId)
+	}
+	found, err := FindFiles(db, fileIds)
+	if err != nil {
+		return err
+	}
+	for _, fileId := range fileIds {
+		f, ok := found[fileId]
+		if !ok {
+			return ErrFileNotFound.WithInternalMsg("file not found, fileId: %v", fileId)
+		}
+		if f.IsDeleted() {
+			return ErrFileDeleted.WithInternalMsg("file deleted, fileId: %v", fileId)
+		}
+	}
+	master := found[playlistFileId]
+	if master.Sha1 == "" {
+		return ErrNotHlsPlaylist.WithInternalMsg("checksum of the master playlist is not computed, fileId: %v", playlistFileId)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for i := 0; i < len(files); i += 500 {
+			chunk := files[i:min(i+500, len(files))]
+			var sql strings.Builder
+			sql.WriteString("INSERT INTO file_derivative (source_sha1, source_file_id, operation, params, file_id) VALUES ")
+			args := make([]any, 0, len(chunk)*5)
+			for i, f := range chunk {
+				if i > 0 {
+					sql.WriteString(", ")
+				}
+				sql.WriteString("(?, ?, ?, ?, ?)")
+				args = append(args, master.Sha1, master.FileId, DerivHls, f.Path, f.FileId)
+			}
+			sql.WriteString(" ON DUPLICATE KEY UPDATE source_file_id = VALUES(source_file_id), file_id = VALUES(file_id)")
+			if err := tx.Exec(sql.String(), args...).Error; err != nil {
+				return fmt.Errorf("failed to save HLS files, playlist: %v, %v", playlistFileId, err)
+			}
+		}
+		rail.Infof("Saved %d HLS files of playlist %v", len(files), playlistFileId)
+		return nil
+	})
+}
+
+// Find file registered against the HLS master playlist, see SaveHlsFiles.
+//
+// If fileId is empty, any file registered is returned, i.e., to check whether the playlist is transcoded by hammer.
+func FindHlsFile(db *gorm.DB, playlistFileId string, fileId string) (Derivative, bool, error) {
+	var d Derivative
+	t := db.Table("file_derivative").
+		Select("source_sha1, source_file_id, operation, params, file_id").
+		Where("source_file_id = ? AND operation = ?", playlistFileId, DerivHls)
+	if fileId != "" {
+		t = t.Where("file_id = ?", fileId)
+	}
+	t = t.Limit(1).Scan(&d)
+	if t.Error != nil {
+		return d, false, fmt.Errorf("failed to select HLS file, playlist: %v, fileId: %v, %w", playlistFileId, fileId, t.Error)
+	}
+	return d, t.RowsAffected > 0, nil
+}
+
+// Remove derived files of the content if no file is using the content anymore.
+//
+// The derived files are logically deleted, they are physically removed later along with other deleted files.
+func removeDerivatives(rail miso.Rail, db *gorm.DB, sourceSha1 string) error {
+	if sourceSha1 == "" {
+		return nil
+	}
+
+	var refId int
+	if err := db.Raw("select id from file where sha1 = ? and status != ? limit 1", sourceSha1, api.FileStatusPhysicDel).
+		Scan(&refId).Error; err != nil {
+		return fmt.Errorf("failed to check files using the content, sha1: %v, %v", sourceSha1, err)
+	}
+	if refId > 0 {
+		return nil // the content is still used
+	}
+
+	var fileIds []string
+	if err := db.Raw("select file_id from file_derivative where source_sha1 = ?", sourceSha1).Scan(&fileIds).Error; err != nil {
+		return fmt.Errorf("failed to select file derivatives, sha1: %v, %v", sourceSha1, err)
+	}
+	for _, fileId := range fileIds {
+		if err := LDelFile(rail, db, fileId); err != nil && !errors.Is(err, ErrFileDeleted) && !errors.Is(err, ErrFileNotFound) {
+			return fmt.Errorf("failed to delete derived file, fileId: %v, %w", fileId, err)
+		}
+	}
+	if err := db.Exec("delete from file_derivative where source_sha1 = ?", sourceSha1).Error; err != nil {
+		return fmt.Errorf("failed to delete file derivatives, sha1: %v, %v", sourceSha1, err)
+	}
+	if len(fileIds) > 0 {
+		rail.Infof("Removed %d derived files of content %v", len(fileIds), sourceSha1)
+	}
+	return nil
+}
@@ -19,6 +19,11 @@ var (
 	UnzipPipeline = rabbit.NewEventPipeline[UnzipFileEvent]("mini-fstore.unzip.pipeline").
 			LogPayload().
 			MaxRetry(3)
+
+	// Pipeline to extract media metadata of uploaded files, the listener is registered by hammer.
+	ExtractMetadataPipeline = rabbit.NewEventPipeline[ExtractMetadataEvent]("mini-fstore.metadata.extract.pipeline").
+				LogPayload().
+				MaxRetry(3)
 )
 
 func InitPipeline(rail miso.Rail) error {
@@ -26,6 +31,10 @@ func InitPipeline(rail miso.Rail) error {
 	return nil
 }
 
+type ExtractMetadataEvent struct {
+	FileId string `valid:"notEmpty"`
+}
+
 type UnzipFileEvent struct {
 	FileId          string `valid:"notEmpty"`
 	ReplyToEventBus string `valid:"notEmpty"`
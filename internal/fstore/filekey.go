@@ -0,0 +1,396 @@
+package fstore
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/miso/encoding"
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+)
+
+const (
+	fileKeyPrefix      = "fstore:file:key:"       // file key -> CachedFile
+	fileKeyUsesPrefix  = "fstore:file:key:uses:"  // file key -> number of downloads
+	fileKeyIndexPrefix = "fstore:file:key:index:" // file id -> set of file keys
+
+	fileKeyTtl = 30 * time.Minute
+
+	MaxBatchSize = 1000 // max number of files in batch operations
+)
+
+var (
+	ErrInvalidFileKeyOptions = miso.NewErrf("Invalid file key options").WithCode(api.InvalidRequest)
+	ErrBatchTooLarge         = miso.NewErrf("Too many files in one batch").WithCode(api.InvalidRequest)
+)
+
+// Restrictions of the random file key, the key is unrestricted if all options are absent.
+type FileKeyOptions struct {
+	Scope    string `json:"scope,omitempty"`    // api.FileOpDownload or api.FileOpStream, both are allowed if empty
+	MaxUses  int    `json:"maxUses,omitempty"`  // max number of downloads, Scope must be api.FileOpDownload
+	ExpireAt int64  `json:"expireAt,omitempty"` // absolute expiry (unix timestamp in seconds) regardless of refresh
+	ClientIp string `json:"clientIp,omitempty"` // the only client ip allowed to use the key
+	Referer  string `json:"referer,omitempty"`  // prefix of the referer required to use the key
+}
+
+func init() {
+	miso.SetDefProp(config.PropTrustedProxies, []string{"127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"})
+}
+
+func (o FileKeyOptions) validate() error {
+	if o.Scope != "" && o.Scope != api.FileOpDownload && o.Scope != api.FileOpStream {
+		return ErrInvalidFileKeyOptions.WithInternalMsg("illegal scope: '%v'", o.Scope)
+	}
+	if o.MaxUses < 0 {
+		return ErrInvalidFileKeyOptions.WithInternalMsg("illegal maxUses: %v", o.MaxUses)
+	}
+	// streaming requests are not counted, the key must not be usable for streaming, or the file can be fetched
+	// any number of times using range requests
+	if o.MaxUses > 0 && o.Scope != api.FileOpDownload {
+		return ErrInvalidFileKeyOptions.WithInternalMsg("maxUses is only applicable to download-only key, scope: '%v'", o.Scope)
+	}
+	return nil
+}
+
+// Ttl of the key capped by the absolute expiry, returns false if the key has expired.
+func (o FileKeyOptions) ttl(ttl time.Duration) (time.Duration, bool) {
+	if o.ExpireAt < 1 {
+		return ttl, true
+	}
+	remaining := time.Until(time.Unix(o.ExpireAt, 0))
+	if remaining <= 0 {
+		return 0, false
+	}
+	return min(ttl, remaining), true
+}
+
+// How the file is accessed using the file key.
+type FileAccess struct {
+	Op       string // api.FileOpDownload or api.FileOpStream
+	ClientIp string
+	Referer  string
+}
+
+// Build FileAccess of the http request.
+func NewFileAccess(r *http.Request, op string) FileAccess {
+	return FileAccess{Op: op, ClientIp: ClientIp(r), Referer: r.Referer()}
+}
+
+// Resolve client ip of the request.
+//
+// X-Forwarded-For and X-Real-IP are only respected if the request is sent by one of the trusted proxies
+// ('fstore.trusted-proxies'). X-Forwarded-For is walked from right to left, the first entry that is not a trusted
+// proxy is the client, entries on the left of it are set by the client and can't be trusted.
+func ClientIp(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !isTrustedProxy(remote) {
+		return remote
+	}
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		hops := strings.Split(fwd, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(hops[i])
+			if i == 0 || !isTrustedProxy(ip) {
+				return ip
+			}
+		}
+	}
+	if ip := r.Header.Get("X-Real-IP"); ip != "" {
+		return strings.TrimSpace(ip)
+	}
+	return remote
+}
+
+var (
+	trustedProxies     []*net.IPNet
+	trustedProxiesOnce sync.Once
+)
+
+// Check whether the ip belongs to one of the trusted proxies configured in 'fstore.trusted-proxies'.
+func isTrustedProxy(ip string) bool {
+	trustedProxiesOnce.Do(func() {
+		trustedProxies = parseCidrs(miso.GetPropStrSlice(config.PropTrustedProxies))
+	})
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse CIDRs, plain ip addresses are treated as single host networks, invalid ones are ignored.
+func parseCidrs(cidrs []string) []*net.IPNet {
+	l := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if !strings.Contains(c, "/") {
+			if ip := net.ParseIP(c); ip != nil {
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 8*net.IPv4len
+				}
+				l = append(l, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			miso.Warnf("Invalid trusted proxy '%v', %v", c, err)
+			continue
+		}
+		l = append(l, n)
+	}
+	return l
+}
+
+// Check whether the access is allowed by the options, the use of the key is not counted.
+func (o FileKeyOptions) allows(acc FileAccess) error {
+	if o.Scope != "" && o.Scope != acc.Op {
+		return ErrFileNotFound.WithInternalMsg("%v, op: %v, scope: %v", api.ErrFileOpNotAllowed, acc.Op, o.Scope)
+	}
+	if o.ExpireAt > 0 && time.Now().Unix() >= o.ExpireAt {
+		return ErrFileNotFound.WithInternalMsg("file key expired at %v", o.ExpireAt)
+	}
+	if o.ClientIp != "" && o.ClientIp != acc.ClientIp {
+		return ErrFileNotFound.WithInternalMsg("file key is bound to client ip %v, got %v", o.ClientIp, acc.ClientIp)
+	}
+	if o.Referer != "" && !strings.HasPrefix(acc.Referer, o.Referer) {
+		return ErrFileNotFound.WithInternalMsg("file key is bound to referer %v, got %v", o.Referer, acc.Referer)
+	}
+	return nil
+}
+
+// Count the download, the key is revoked once it's used up.
+func useFileKey(rail miso.Rail, fileKey string, cf CachedFile) error {
+	if cf.MaxUses < 1 {
+		return nil
+	}
+	k := fileKeyUsesPrefix + fileKey
+	c := redis.GetRedis().Incr(k)
+	if c.Err() != nil {
+		return c.Err()
+	}
+	if ttl, ok := cf.ttl(fileKeyTtl); ok {
+		redis.GetRedis().Expire(k, ttl)
+	}
+	if c.Val() > int64(cf.MaxUses) {
+		return ErrFileNotFound.WithInternalMsg("file key is used up, max uses: %v", cf.MaxUses)
+	}
+	if c.Val() == int64(cf.MaxUses) {
+		rail.Infof("File key used up, revoking, fileId: %v", cf.FileId)
+		if err := RevokeFileKey(rail, fileKey); err != nil {
+			rail.Warnf("Failed to revoke used up file key, %v", err)
+		}
+	}
+	return nil
+}
+
+// Expiry of the file key index, the index is extended to the end of the next period.
+//
+// Keys never live longer than fileKeyTtl after they are created or refreshed, so an index extended at any time
+// within the current period won't expire before any of the keys. The expiry only moves forward, no matter which
+// node extends it.
+func fileKeyIndexExpiry(now time.Time) (period int64, expiry time.Time) {
+	secs := int64(fileKeyTtl / time.Second)
+	period = now.Unix() / secs
+	return period, time.Unix((period+2)*secs, 0)
+}
+
+// File ids of which the index has been extended by this node in the current period.
+var fileKeyIndexExtended = struct {
+	sync.Mutex
+	period  int64
+	fileIds map[string]struct{}
+}{fileIds: map[string]struct{}{}}
+
+// Mark the index extended in the period, returns false if it's already extended.
+func markFileKeyIndexExtended(period int64, fileId string) bool {
+	fileKeyIndexExtended.Lock()
+	defer fileKeyIndexExtended.Unlock()
+	if fileKeyIndexExtended.period != period {
+		fileKeyIndexExtended.period = period
+		fileKeyIndexExtended.fileIds = map[string]struct{}{}
+	}
+	if _, ok := fileKeyIndexExtended.fileIds[fileId]; ok {
+		return false
+	}
+	fileKeyIndexExtended.fileIds[fileId] = struct{}{}
+	return true
+}
+
+// Record the key in the index of the file, so that keys of the file can be revoked all at once.
+func indexFileKey(rail miso.Rail, fileId string, fileKey string) {
+	k := fileKeyIndexPrefix + fileId
+	period, expiry := fileKeyIndexExpiry(time.Now())
+	pipe := redis.GetRedis().Pipeline()
+	defer pipe.Close()
+	pipe.SAdd(k, fileKey)
+	pipe.ExpireAt(k, expiry)
+	if _, err := pipe.Exec(); err != nil {
+		rail.Warnf("Failed to index file key, fileId: %v, %v", fileId, err)
+		return
+	}
+	markFileKeyIndexExtended(period, fileId)
+}
+
+// Extend the index of the file as the key is refreshed, the index is extended at most once per period on each node.
+func extendFileKeyIndex(rail miso.Rail, fileId string) {
+	period, expiry := fileKeyIndexExpiry(time.Now())
+	if !markFileKeyIndexExtended(period, fileId) {
+		return
+	}
+	if err := redis.GetRedis().ExpireAt(fileKeyIndexPrefix+fileId, expiry).Err(); err != nil {
+		rail.Warnf("Failed to extend file key index, fileId: %v, %v", fileId, err)
+	}
+}
+
+// Revoke the file key.
+func RevokeFileKey(rail miso.Rail, fileKey string) error {
+	ok, cf := ResolveFileKey(rail, fileKey)
+	if err := redis.GetRedis().Del(fileKeyPrefix+fileKey, fileKeyUsesPrefix+fileKey).Err(); err != nil {
+		return err
+	}
+	if ok {
+		redis.GetRedis().SRem(fileKeyIndexPrefix+cf.FileId, fileKey)
+	}
+	rail.Infof("Revoked file key of %v", cf.FileId)
+	return nil
+}
+
+// Revoke all the file keys of the file, signed file keys are not affected.
+func RevokeFileKeys(rail miso.Rail, fileId string) error {
+	k := fileKeyIndexPrefix + fileId
+	c := redis.GetRedis().SMembers(k)
+	if c.Err() != nil {
+		return c.Err()
+	}
+	keys := make([]string, 0, len(c.Val())*2+1)
+	for _, fk := range c.Val() {
+		keys = append(keys, fileKeyPrefix+fk, fileKeyUsesPrefix+fk)
+	}
+	keys = append(keys, k)
+	if err := redis.GetRedis().Del(keys...).Err(); err != nil {
+		return err
+	}
+	rail.Infof("Revoked %d file keys of %v", len(c.Val()), fileId)
+	return nil
+}
+
+// Resolve CachedFile and the DFile for the given file key, it can either be a temporary file key
+// (generated by RandFileKey) or a signed file key.
+//
+// The ttl of temporary file key is extended for streaming, downloads are counted if the key has max uses.
+// ErrFileNotFound or ErrFileDeleted is returned if the file is not available.
+func ResolveFileAccess(rail miso.Rail, fileKey string, acc FileAccess) (CachedFile, DFile, error) {
+	if !api.IsSignedFileKey(fileKey) {
+		cachedFile, ff, err := ResolveDFileKey(rail, fileKey)
+		if err != nil {
+			return cachedFile, ff, err
+		}
+		if err := cachedFile.allows(acc); err != nil {
+			return cachedFile, ff, err
+		}
+		if acc.Op == api.FileOpStream {
+			if err := RefreshFileKeyExp(rail, fileKey, cachedFile); err != nil {
+				return cachedFile, ff, err
+			}
+		} else if err := useFileKey(rail, fileKey, cachedFile); err != nil {
+			return cachedFile, ff, err
+		}
+		return cachedFile, ff, nil
+	}
+
+	cachedFile, err := ResolveSignedFileKey(rail, fileKey, acc.Op)
+	if err != nil {
+		return cachedFile, DFile{}, err
+	}
+	ff, err := findDFile(cachedFile.FileId)
+	if err != nil {
+		return cachedFile, ff, ErrFileNotFound
+	}
+	if ff.IsDeleted() {
+		return cachedFile, ff, ErrFileDeleted
+	}
+	return cachedFile, ff, nil
+}
+
+// Create random file keys for the files in batch, all the keys share the same options.
+//
+// All the files must exist, they are validated in one query, and the keys are written using a Redis pipeline.
+// Returns fileId -> file key, the name of the first occurrence is used if a file appears more than once.
+func RandFileKeys(rail miso.Rail, files []CachedFile, opts FileKeyOptions) (map[string]string, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if len(files) < 1 {
+		return map[string]string{}, nil
+	}
+	if len(files) > MaxBatchSize {
+		return nil, ErrBatchTooLarge.WithInternalMsg("batch size: %v", len(files))
+	}
+	ttl, ok := opts.ttl(fileKeyTtl)
+	if !ok {
+		return nil, ErrInvalidFileKeyOptions.WithInternalMsg("expireAt is in the past: %v", opts.ExpireAt)
+	}
+
+	distinct := make([]CachedFile, 0, len(files))
+	fileIds := make([]string, 0, len(files))
+	seen := make(map[string]struct{}, len(files))
+	for _, f := range files {
+		if _, ok := seen[f.FileId]; ok {
+			continue
+		}
+		seen[f.FileId] = struct{}{}
+		distinct = append(distinct, f)
+		fileIds = append(fileIds, f.FileId)
+	}
+	var found []string
+	if err := mysql.GetMySQL().Raw("select file_id from file where file_id in ? and status = ?", fileIds, api.FileStatusNormal).
+		Scan(&found).Error; err != nil {
+		return nil, fmt.Errorf("failed to select file from DB, %w", err)
+	}
+	if len(found) != len(fileIds) {
+		return nil, ErrFileNotFound.WithInternalMsg("%d of %d files are not found or deleted", len(fileIds)-len(found), len(fileIds))
+	}
+
+	keys := make(map[string]string, len(distinct))
+	period, expiry := fileKeyIndexExpiry(time.Now())
+	pipe := redis.GetRedis().Pipeline()
+	defer pipe.Close()
+	for _, f := range distinct {
+		fk := util.ERand(30)
+		sby, err := encoding.WriteJson(CachedFile{Name: f.Name, FileId: f.FileId, FileKeyOptions: opts})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal to CachedFile, %v", err)
+		}
+		pipe.Set(fileKeyPrefix+fk, string(sby), ttl)
+
+		pipe.SAdd(fileKeyIndexPrefix+f.FileId, fk)
+		pipe.ExpireAt(fileKeyIndexPrefix+f.FileId, expiry)
+		keys[f.FileId] = fk
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return nil, fmt.Errorf("failed to save file keys, %v", err)
+	}
+	for _, f := range distinct {
+		markFileKeyIndexExtended(period, f.FileId)
+	}
+	rail.Infof("Generated %d random keys (options: %+v)", len(keys), opts)
+	return keys, nil
+}
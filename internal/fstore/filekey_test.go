@@ -0,0 +1,96 @@
+package fstore
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+)
+
+func TestFileKeyOptions(t *testing.T) {
+	if err := (FileKeyOptions{Scope: "upload"}).validate(); err == nil {
+		t.Fatal("expected error for illegal scope")
+	}
+	if err := (FileKeyOptions{Scope: api.FileOpStream, MaxUses: 1}).validate(); err == nil {
+		t.Fatal("expected error for stream-only key with max uses")
+	}
+	if err := (FileKeyOptions{MaxUses: 1}).validate(); err == nil {
+		t.Fatal("expected error for unscoped key with max uses")
+	}
+	if err := (FileKeyOptions{Scope: api.FileOpDownload, MaxUses: 1}).validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl, ok := (FileKeyOptions{}).ttl(fileKeyTtl); !ok || ttl != fileKeyTtl {
+		t.Fatalf("expected %v, got %v", fileKeyTtl, ttl)
+	}
+	if ttl, ok := (FileKeyOptions{ExpireAt: time.Now().Add(time.Minute).Unix()}).ttl(fileKeyTtl); !ok || ttl > time.Minute {
+		t.Fatalf("ttl should be capped by expireAt, got %v", ttl)
+	}
+	if _, ok := (FileKeyOptions{ExpireAt: time.Now().Add(-time.Minute).Unix()}).ttl(fileKeyTtl); ok {
+		t.Fatal("key should have expired")
+	}
+
+	o := FileKeyOptions{Scope: api.FileOpDownload, ClientIp: "10.0.0.1", Referer: "https://example.com/"}
+	acc := FileAccess{Op: api.FileOpDownload, ClientIp: "10.0.0.1", Referer: "https://example.com/gallery"}
+	if err := o.allows(acc); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range []FileAccess{
+		{Op: api.FileOpStream, ClientIp: acc.ClientIp, Referer: acc.Referer},
+		{Op: acc.Op, ClientIp: "10.0.0.2", Referer: acc.Referer},
+		{Op: acc.Op, ClientIp: acc.ClientIp, Referer: "https://evil.com/"},
+	} {
+		if err := o.allows(a); err == nil {
+			t.Fatalf("%+v should not be allowed", a)
+		}
+	}
+}
+
+func TestClientIp(t *testing.T) {
+	r := httptest.NewRequest("GET", "/file/raw", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	if ip := ClientIp(r); ip != "10.0.0.1" {
+		t.Fatalf("expected 10.0.0.1, got %v", ip)
+	}
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.2")
+	if ip := ClientIp(r); ip != "203.0.113.7" {
+		t.Fatalf("expected 203.0.113.7, got %v", ip)
+	}
+
+	// spoofed by the client, the proxy appends the actual client ip
+	r.Header.Set("X-Forwarded-For", "10.0.0.9, 203.0.113.7")
+	if ip := ClientIp(r); ip != "203.0.113.7" {
+		t.Fatalf("expected 203.0.113.7, got %v", ip)
+	}
+
+	// not sent by trusted proxy, the headers are ignored
+	r.RemoteAddr = "203.0.113.7:1234"
+	r.Header.Set("X-Forwarded-For", "10.0.0.9")
+	r.Header.Set("X-Real-IP", "10.0.0.9")
+	if ip := ClientIp(r); ip != "203.0.113.7" {
+		t.Fatalf("expected 203.0.113.7, got %v", ip)
+	}
+}
+
+func TestFileKeyIndexExpiry(t *testing.T) {
+	now := time.Now()
+	period, expiry := fileKeyIndexExpiry(now)
+	if !expiry.After(now.Add(fileKeyTtl)) {
+		t.Fatalf("index expires at %v before the key refreshed at %v", expiry, now)
+	}
+	if _, next := fileKeyIndexExpiry(now.Add(fileKeyTtl)); !next.After(expiry) {
+		t.Fatalf("expiry should move forward, %v, %v", expiry, next)
+	}
+
+	if !markFileKeyIndexExtended(period, "file_1") {
+		t.Fatal("index should be extended")
+	}
+	if markFileKeyIndexExtended(period, "file_1") {
+		t.Fatal("index is already extended in the period")
+	}
+	if !markFileKeyIndexExtended(period+1, "file_1") {
+		t.Fatal("index should be extended in the next period")
+	}
+}
@@ -0,0 +1,274 @@
+package fstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	JobRemoveDeletedFiles = "remove-deleted"   // maintenance job - RemoveDeletedFiles
+	JobSanitizeStorage    = "sanitize-storage" // maintenance job - SanitizeStorage
+	JobComputeChecksum    = "compute-checksum" // maintenance job - ComputeFilesChecksum
+	JobRepairBlobs        = "repair-blobs"     // maintenance job - RepairBlobs
+	JobPurgeTrash         = "purge-trash"      // maintenance job - PurgeTrash
+
+	JobStatusRunning   = "RUNNING"
+	JobStatusCompleted = "COMPLETED"
+	JobStatusFailed    = "FAILED"
+	JobStatusCancelled = "CANCELLED"
+
+	jobLockPrefix   = "mini-fstore:maintenance:job:lock:"   // job name -> lock held by the leader running the job
+	jobCancelPrefix = "mini-fstore:maintenance:job:cancel:" // job id -> cancellation flag
+
+	jobFlushInterval = 3 * time.Second
+	maxJobHistory    = 100
+)
+
+var (
+	ErrJobCancelled  = errors.New("maintenance job cancelled")
+	ErrUnknownJob    = miso.NewErrf("Unknown maintenance job").WithCode(api.InvalidRequest)
+	ErrJobNotFound   = miso.NewErrf("Maintenance job is not found").WithCode(api.InvalidRequest)
+	ErrJobRunning    = miso.NewErrf("Maintenance job is running, please try again later").WithCode(api.InvalidRequest)
+	ErrJobNotRunning = miso.NewErrf("Maintenance job is not running").WithCode(api.InvalidRequest)
+
+	maintenanceJobs = map[string]func(rail miso.Rail, db *gorm.DB, p *JobProgress) error{
+		JobRemoveDeletedFiles: removeDeletedFiles,
+		JobSanitizeStorage: func(rail miso.Rail, db *gorm.DB, p *JobProgress) error {
+			return sanitizeStorage(rail, p)
+		},
+		JobComputeChecksum: computeFilesChecksum,
+		JobRepairBlobs:     repairBlobs,
+		JobPurgeTrash:      purgeTrash,
+	}
+)
+
+type MaintenanceJob struct {
+	Id        int64       `json:"-"`
+	JobId     string      `json:"jobId"`
+	Name      string      `json:"name"`
+	Status    string      `json:"status"`
+	Node      string      `json:"node"`
+	Total     int64       `json:"total"`
+	Processed int64       `json:"processed"`
+	Failed    int64       `json:"failed"`
+	Error     string      `json:"error"`
+	StartTime *util.ETime `json:"startTime"`
+	EndTime   *util.ETime `json:"endTime"`
+}
+
+// Progress of the running maintenance job.
+//
+// Methods of nil *JobProgress are no-op, so that the maintenance operations can still be called without the job.
+type JobProgress struct {
+	total     atomic.Int64
+	processed atomic.Int64
+	failed    atomic.Int64
+	cancelled atomic.Bool
+}
+
+func (p *JobProgress) SetTotal(n int) {
+	if p != nil {
+		p.total.Store(int64(n))
+	}
+}
+
+func (p *JobProgress) AddProcessed(n int) {
+	if p != nil {
+		p.processed.Add(int64(n))
+	}
+}
+
+func (p *JobProgress) AddFailed(n int) {
+	if p != nil {
+		p.failed.Add(int64(n))
+	}
+}
+
+// Check whether the job is cancelled, the operation should stop and return ErrJobCancelled.
+func (p *JobProgress) Cancelled() bool {
+	return p != nil && p.cancelled.Load()
+}
+
+type runningJob struct {
+	MaintenanceJob
+	lock     *redis.RLock
+	run      func(rail miso.Rail, db *gorm.DB, p *JobProgress) error
+	progress *JobProgress
+}
+
+// Become the leader of the job using Redis lock, and create the job record.
+func startMaintenanceJob(rail miso.Rail, db *gorm.DB, name string) (*runningJob, error) {
+	run, ok := maintenanceJobs[name]
+	if !ok {
+		return nil, ErrUnknownJob.WithInternalMsg("job: '%v'", name)
+	}
+
+	lock := redis.NewCustomRLock(rail, jobLockPrefix+name, redis.RLockConfig{BackoffDuration: 1 * time.Second})
+	if err := lock.Lock(); err != nil {
+		return nil, ErrJobRunning.WithInternalMsg("failed to obtain lock of %v, %v", name, err)
+	}
+
+	// jobs that are still RUNNING are interrupted, e.g., the node crashed, the lock is held otherwise
+	now := time.Now()
+	if err := db.Exec("update maintenance_job set status = ?, error = ?, end_time = ? where name = ? and status = ?",
+		JobStatusFailed, "interrupted", now, name, JobStatusRunning).Error; err != nil {
+		lock.Unlock()
+		return nil, fmt.Errorf("failed to update interrupted maintenance jobs, %v", err)
+	}
+
+	node, _ := os.Hostname()
+	st := util.ToETime(now)
+	j := &runningJob{
+		MaintenanceJob: MaintenanceJob{
+			JobId:     util.GenIdP("job_"),
+			Name:      name,
+			Status:    JobStatusRunning,
+			Node:      node,
+			StartTime: &st,
+		},
+		lock:     lock,
+		run:      run,
+		progress: &JobProgress{},
+	}
+	if err := db.Exec("insert into maintenance_job (job_id, name, status, node, start_time) values (?, ?, ?, ?, ?)",
+		j.JobId, j.Name, j.Status, j.Node, now).Error; err != nil {
+		lock.Unlock()
+		return nil, fmt.Errorf("failed to save maintenance job, %v", err)
+	}
+	rail.Infof("Started maintenance job %v (%v) on %v", j.JobId, name, node)
+	return j, nil
+}
+
+// Run the job, progress is flushed to database periodically, and the cancellation flag is checked at the same time.
+func (j *runningJob) exec(rail miso.Rail, db *gorm.DB) (err error) {
+	defer j.lock.Unlock()
+	defer miso.TimeOp(rail, time.Now(), "MaintenanceJob "+j.Name)
+
+	ticker := miso.NewTickRuner(jobFlushInterval, func() {
+		if redis.GetRedis().Exists(jobCancelPrefix+j.JobId).Val() > 0 {
+			j.progress.cancelled.Store(true)
+		}
+		j.flush(rail, db, JobStatusRunning, "")
+	})
+	ticker.Start()
+
+	defer func() {
+		ticker.Stop()
+		if v := recover(); v != nil {
+			err = fmt.Errorf("maintenance job panicked, %v", v)
+		}
+		status, msg := JobStatusCompleted, ""
+		if errors.Is(err, ErrJobCancelled) {
+			status = JobStatusCancelled
+		} else if err != nil {
+			status, msg = JobStatusFailed, err.Error()
+		}
+		j.flush(rail, db, status, msg)
+		redis.GetRedis().Del(jobCancelPrefix + j.JobId)
+		rail.Infof("Maintenance job %v (%v) %v, processed: %v, failed: %v", j.JobId, j.Name, status,
+			j.progress.processed.Load(), j.progress.failed.Load())
+	}()
+
+	return j.run(rail, db, j.progress)
+}
+
+// Update status and progress of the job, the job record is only updated while it's RUNNING, so that a late periodic
+// flush never overwrites the final status.
+func (j *runningJob) flush(rail miso.Rail, db *gorm.DB, status string, errMsg string) {
+	var endTime *time.Time
+	if status != JobStatusRunning {
+		now := time.Now()
+		endTime = &now
+	}
+	if len(errMsg) > 1000 {
+		errMsg = errMsg[:1000]
+	}
+	err := db.Exec(`update maintenance_job set status = ?, total = ?, processed = ?, failed = ?, error = ?, end_time = ?
+		where job_id = ? and status = ?`, status, j.progress.total.Load(), j.progress.processed.Load(), j.progress.failed.Load(),
+		errMsg, endTime, j.JobId, JobStatusRunning).Error
+	if err != nil {
+		rail.Errorf("Failed to update maintenance job %v, %v", j.JobId, err)
+	}
+}
+
+// Submit maintenance job, the job is run asynchronously, returns the job id.
+//
+// Only one job of the same name runs at a time across all nodes, ErrJobRunning is returned otherwise.
+func SubmitMaintenanceJob(rail miso.Rail, db *gorm.DB, name string) (string, error) {
+	j, err := startMaintenanceJob(rail, db, name)
+	if err != nil {
+		return "", err
+	}
+	go func(rail miso.Rail) {
+		if err := j.exec(rail, db); err != nil && !errors.Is(err, ErrJobCancelled) {
+			rail.Errorf("Maintenance job %v (%v) failed, %v", j.JobId, j.Name, err)
+		}
+	}(rail.NextSpan())
+	return j.JobId, nil
+}
+
+// Run maintenance job synchronously, e.g., in scheduled tasks, the job is recorded the same way as the submitted ones.
+func RunMaintenanceJob(rail miso.Rail, db *gorm.DB, name string) error {
+	j, err := startMaintenanceJob(rail, db, name)
+	if err != nil {
+		return err
+	}
+	err = j.exec(rail, db)
+	if errors.Is(err, ErrJobCancelled) {
+		return nil
+	}
+	return err
+}
+
+// Request cancellation of the running job, the job is stopped by the leader shortly after.
+func CancelMaintenanceJob(rail miso.Rail, db *gorm.DB, jobId string) error {
+	j, err := FindMaintenanceJob(db, jobId)
+	if err != nil {
+		return err
+	}
+	if j.Status != JobStatusRunning {
+		return ErrJobNotRunning.WithInternalMsg("job %v is %v", jobId, j.Status)
+	}
+	if err := redis.GetRedis().Set(jobCancelPrefix+jobId, 1, time.Hour).Err(); err != nil {
+		return fmt.Errorf("failed to set cancellation flag, %v", err)
+	}
+	rail.Infof("Requested cancellation of maintenance job %v (%v)", jobId, j.Name)
+	return nil
+}
+
+func FindMaintenanceJob(db *gorm.DB, jobId string) (MaintenanceJob, error) {
+	var j MaintenanceJob
+	if err := db.Raw("select * from maintenance_job where job_id = ?", strings.TrimSpace(jobId)).Scan(&j).Error; err != nil {
+		return j, fmt.Errorf("failed to select maintenance job, %v", err)
+	}
+	if j.Id < 1 {
+		return j, ErrJobNotFound.WithInternalMsg("jobId: %v", jobId)
+	}
+	return j, nil
+}
+
+// List the latest maintenance jobs, jobs of all names are listed if name is empty.
+func ListMaintenanceJobs(db *gorm.DB, name string, limit int) ([]MaintenanceJob, error) {
+	if limit < 1 || limit > maxJobHistory {
+		limit = maxJobHistory
+	}
+	t := db.Table("maintenance_job")
+	if name != "" {
+		t = t.Where("name = ?", name)
+	}
+	var l []MaintenanceJob
+	if err := t.Order("id desc").Limit(limit).Scan(&l).Error; err != nil {
+		return nil, fmt.Errorf("failed to list maintenance jobs, %v", err)
+	}
+	return l, nil
+}
@@ -0,0 +1,25 @@
+package fstore
+
+import "testing"
+
+func TestJobProgress(t *testing.T) {
+	var nilp *JobProgress
+	nilp.SetTotal(10)
+	nilp.AddProcessed(1)
+	nilp.AddFailed(1)
+	if nilp.Cancelled() {
+		t.Fatal("nil JobProgress is never cancelled")
+	}
+
+	p := &JobProgress{}
+	p.SetTotal(10)
+	p.AddProcessed(2)
+	p.AddFailed(1)
+	if p.total.Load() != 10 || p.processed.Load() != 2 || p.failed.Load() != 1 {
+		t.Fatalf("unexpected progress, total: %v, processed: %v, failed: %v", p.total.Load(), p.processed.Load(), p.failed.Load())
+	}
+	p.cancelled.Store(true)
+	if !p.Cancelled() {
+		t.Fatal("JobProgress should be cancelled")
+	}
+}
@@ -0,0 +1,191 @@
+package fstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"gorm.io/gorm"
+)
+
+const (
+	MediaTypeImage = "image"
+	MediaTypeVideo = "video"
+	MediaTypeAudio = "audio"
+)
+
+var (
+	mediaTypeByExt = map[string]string{
+		".jpg":  MediaTypeImage,
+		".jpeg": MediaTypeImage,
+		".png":  MediaTypeImage,
+		".gif":  MediaTypeImage,
+		".webp": MediaTypeImage,
+		".bmp":  MediaTypeImage,
+		".tif":  MediaTypeImage,
+		".tiff": MediaTypeImage,
+		".heic": MediaTypeImage,
+		".heif": MediaTypeImage,
+		".mp4":  MediaTypeVideo,
+		".mov":  MediaTypeVideo,
+		".mkv":  MediaTypeVideo,
+		".webm": MediaTypeVideo,
+		".avi":  MediaTypeVideo,
+		".mp3":  MediaTypeAudio,
+		".m4a":  MediaTypeAudio,
+		".flac": MediaTypeAudio,
+		".wav":  MediaTypeAudio,
+		".ogg":  MediaTypeAudio,
+		".aac":  MediaTypeAudio,
+		".opus": MediaTypeAudio,
+	}
+)
+
+var (
+	ErrNotMediaFile = miso.NewErrf("Not an image, video or audio").WithCode(api.NotMediaFile)
+)
+
+func init() {
+	miso.SetDefProp(config.PropExtractMetadataOnUpload, true)
+	miso.SetDefProp(config.PropProbeVideoOnUpload, false)
+}
+
+// Guess media type of the file using it's name, empty string is returned if it's unknown.
+func GuessMediaType(name string) string {
+	return mediaTypeByExt[strings.ToLower(filepath.Ext(name))]
+}
+
+type FileMetadata struct {
+	FileId      string
+	Format      string
+	Width       int
+	Height      int
+	CaptureTime *util.ETime
+	CameraModel string
+	DurationMs  int64
+	VideoCodec  string
+	AudioCodec  string
+	Bitrate     int64
+}
+
+func (m FileMetadata) ToApi() *api.FileMetadata {
+	return &api.FileMetadata{
+		Format:      m.Format,
+		Width:       m.Width,
+		Height:      m.Height,
+		CaptureTime: m.CaptureTime,
+		CameraModel: m.CameraModel,
+		DurationMs:  m.DurationMs,
+		VideoCodec:  m.VideoCodec,
+		AudioCodec:  m.AudioCodec,
+		Bitrate:     m.Bitrate,
+	}
+}
+
+// Save file metadata, existing one is overwritten.
+func FileMetadataFromApi(fileId string, m api.FileMetadata) FileMetadata {
+	return FileMetadata{
+		FileId:      fileId,
+		Format:      m.Format,
+		Width:       m.Width,
+		Height:      m.Height,
+		CaptureTime: m.CaptureTime,
+		CameraModel: m.CameraModel,
+		DurationMs:  m.DurationMs,
+		VideoCodec:  m.VideoCodec,
+		AudioCodec:  m.AudioCodec,
+		Bitrate:     m.Bitrate,
+	}
+}
+
+func SaveFileMetadata(rail miso.Rail, db *gorm.DB, m FileMetadata) error {
+	err := db.Exec(`
+		INSERT INTO file_metadata (file_id, format, width, height, capture_time, camera_model, duration_ms, video_codec, audio_codec, bitrate)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE format = VALUES(format), width = VALUES(width), height = VALUES(height),
+			capture_time = VALUES(capture_time), camera_model = VALUES(camera_model), duration_ms = VALUES(duration_ms),
+			video_codec = VALUES(video_codec), audio_codec = VALUES(audio_codec), bitrate = VALUES(bitrate)
+	`, m.FileId, m.Format, m.Width, m.Height, m.CaptureTime, m.CameraModel, m.DurationMs, m.VideoCodec, m.AudioCodec, m.Bitrate).Error
+	if err != nil {
+		return fmt.Errorf("failed to save file metadata, fileId: %v, %v", m.FileId, err)
+	}
+	rail.Infof("Saved file metadata: %+v", m)
+	return nil
+}
+
+// Find file metadata, returns false if it's absent.
+func FindFileMetadata(db *gorm.DB, fileId string) (FileMetadata, bool, error) {
+	var m FileMetadata
+	t := db.Raw(`
+		SELECT file_id, format, width, height, capture_time, camera_model, duration_ms, video_codec, audio_codec, bitrate
+		FROM file_metadata WHERE file_id = ?`, fileId).
+		Scan(&m)
+	if t.Error != nil {
+		return m, false, fmt.Errorf("failed to select file metadata from DB, %w", t.Error)
+	}
+	return m, t.RowsAffected > 0, nil
+}
+
+// Find metadata of the files in one query, returns fileId -> metadata, files without metadata are absent.
+func FindFilesMetadata(db *gorm.DB, fileIds []string) (map[string]FileMetadata, error) {
+	found := make(map[string]FileMetadata, len(fileIds))
+	if len(fileIds) < 1 {
+		return found, nil
+	}
+	var l []FileMetadata
+	t := db.Raw(`
+		SELECT file_id, format, width, height, capture_time, camera_model, duration_ms, video_codec, audio_codec, bitrate
+		FROM file_metadata WHERE file_id IN ?`, fileIds).
+		Scan(&l)
+	if t.Error != nil {
+		return nil, fmt.Errorf("failed to select file metadata from DB, %w", t.Error)
+	}
+	for _, m := range l {
+		found[m.FileId] = m
+	}
+	return found, nil
+}
+
+// Trigger metadata extraction if the uploaded file is a media file.
+func triggerMetadataExtraction(rail miso.Rail, fileId string, name string) {
+	switch GuessMediaType(name) {
+	case MediaTypeImage:
+		if !miso.GetPropBool(config.PropExtractMetadataOnUpload) {
+			return
+		}
+	case MediaTypeVideo:
+		if !miso.GetPropBool(config.PropProbeVideoOnUpload) {
+			return
+		}
+	default:
+		return
+	}
+	if err := ExtractMetadataPipeline.Send(rail, ExtractMetadataEvent{FileId: fileId}); err != nil {
+		rail.Errorf("Failed to trigger metadata extraction, fileId: %v, %v", fileId, err)
+	}
+}
+
+// Trigger metadata extraction of image, video or audio file.
+func TriggerMetadataExtraction(rail miso.Rail, db *gorm.DB, fileId string) error {
+	f, err := FindFile(db, fileId)
+	if err != nil {
+		return ErrUnknownError.WithInternalMsg("FindFile failed, %v", err)
+	}
+	if f.IsZero() {
+		return ErrFileNotFound
+	}
+	if f.IsDeleted() {
+		return ErrFileDeleted
+	}
+	if GuessMediaType(f.Name) == "" {
+		return ErrNotMediaFile
+	}
+	if err := ExtractMetadataPipeline.Send(rail, ExtractMetadataEvent{FileId: fileId}); err != nil {
+		return fmt.Errorf("failed to send event, fileId: %v, %v", fileId, err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package fstore
+
+import (
+	"sync"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/miso/miso"
+)
+
+var (
+	signingKeys     map[string]string // key id -> secret
+	signingKeysOnce sync.Once
+)
+
+// Load signing keys from property 'fstore.signed-url.keys'.
+//
+// Multiple keys can be configured at the same time for key rotation, signed file keys signed by any of them are accepted.
+func loadSigningKeys() map[string]string {
+	signingKeysOnce.Do(func() {
+		var keys []api.SigningKey
+		miso.UnmarshalFromPropKey(config.PropSignedUrlKeys, &keys)
+		signingKeys = make(map[string]string, len(keys))
+		for _, k := range keys {
+			if k.Id == "" || k.Secret == "" {
+				miso.Warnf("Ignored signing key without id or secret, id: '%v'", k.Id)
+				continue
+			}
+			signingKeys[k.Id] = k.Secret
+		}
+	})
+	return signingKeys
+}
+
+func signingKeySecret(keyId string) (string, bool) {
+	s, ok := loadSigningKeys()[keyId]
+	return s, ok
+}
+
+// Resolve CachedFile for the signed file key, the signed file key is verified without Redis.
+func ResolveSignedFileKey(rail miso.Rail, fileKey string, op string) (CachedFile, error) {
+	c, err := api.VerifySignedFileKey(fileKey, signingKeySecret)
+	if err != nil {
+		return CachedFile{}, ErrFileNotFound.WithInternalMsg("failed to verify signed file key, %v", err)
+	}
+	if !c.Allows(op) {
+		return CachedFile{}, ErrFileNotFound.WithInternalMsg("%v, op: %v, allowed: %v", api.ErrFileOpNotAllowed, op, c.Ops)
+	}
+	return CachedFile{FileId: c.FileId, Name: c.Filename}, nil
+}
@@ -0,0 +1,322 @@
+package fstore
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/middleware/task"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+const (
+	purgeTrashBatch = 500
+)
+
+var (
+	trashSizeGauge     = newPromGauge("mini_fstore_trash_size_bytes", "Total size of files in trash dir, updated by PurgeTrashTask")
+	trashFilesGauge    = newPromGauge("mini_fstore_trash_files", "Number of files in trash dir, updated by PurgeTrashTask")
+	trashPurgedCounter = miso.NewPromCounter("mini_fstore_trash_purged_files_total")
+)
+
+func init() {
+	miso.SetDefProp(config.PropTrashRetention, 0)
+	miso.SetDefProp(config.PropTrashPurgeCron, "0 3 * * *")
+	miso.SetDefProp(config.PropPurgeTrashTaskDryRun, false)
+}
+
+func newPromGauge(name string, help string) prometheus.Gauge {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: help})
+	if e := prometheus.DefaultRegisterer.Register(g); e != nil {
+		panic(fmt.Errorf("failed to register gauge %v, %w", name, e))
+	}
+	return g
+}
+
+// Move the blob of the file back from trash dir to storage dir.
+//
+// It's a no-op if the blob is already in storage dir, ErrFileRemoved is returned if the blob is found in neither of them,
+// e.g., it's deleted using the 'direct' strategy.
+func restoreTrashedBlob(rail miso.Rail, fileId string) error {
+	frm := GenTrashPath(fileId)
+	to := GenStoragePath(fileId)
+
+	if _, err := os.Stat(to); err == nil {
+		return nil
+	}
+	if e := os.Rename(frm, to); e != nil {
+		if os.IsNotExist(e) {
+			return ErrFileRemoved.WithInternalMsg("file %v is not found in trash", frm)
+		}
+		return fmt.Errorf("failed to rename file from %s, to %s, %v", frm, to, e)
+	}
+	rail.Infof("Renamed file from %s, to %s", frm, to)
+	return nil
+}
+
+// Restore the file that is deleted logically or physically (using the 'trash' strategy).
+//
+// The blob is moved back from trash dir. If the file is symbolically linked to another file (deduplicated) that has been
+// removed already, the blob of the link target is restored and the link target is marked as logically deleted, so that
+// the blob is only removed again once no one is using it.
+//
+// Restoring a file that is not deleted is a no-op.
+func RestoreFile(rail miso.Rail, db *gorm.DB, fileId string) error {
+	fileId = strings.TrimSpace(fileId)
+	if fileId == "" {
+		return ErrFileIdRequired
+	}
+
+	_, e := redis.RLockRun(rail, FileLockKey(fileId), func() (any, error) {
+		f, er := FindFile(db, fileId)
+		if er != nil {
+			return nil, ErrUnknownError.WithInternalMsg("FindFile failed, %v", er)
+		}
+		if f.IsZero() {
+			return nil, ErrFileNotFound
+		}
+		if !f.IsDeleted() {
+			return nil, nil
+		}
+
+		if f.Link != "" {
+			if err := restoreLinkTarget(rail, db, f.Link); err != nil {
+				return nil, err
+			}
+		} else if f.Status == api.FileStatusPhysicDel {
+			if err := restoreTrashedBlob(rail, fileId); err != nil {
+				return nil, err
+			}
+		}
+
+		t := db.Exec("update file set status = ?, log_del_time = NULL, phy_del_time = NULL, trash_time = NULL where file_id = ? and status = ?",
+			api.FileStatusNormal, fileId, f.Status)
+		if t.Error != nil {
+			return nil, ErrUnknownError.WithInternalMsg("Failed to update file, %v", t.Error)
+		}
+		if err := fileIdExistCache.Del(rail, fileId); err != nil {
+			rail.Warnf("Failed to evict file existence cache, fileId: %v, %v", fileId, err)
+		}
+		rail.Infof("Restored file %v (was %v)", fileId, f.Status)
+		return nil, nil
+	})
+	return e
+}
+
+// Bring back the blob of the link target if it's been removed, the target itself is left logically deleted.
+func restoreLinkTarget(rail miso.Rail, db *gorm.DB, target string) error {
+	_, e := redis.RLockRun(rail, FileLockKey(target), func() (any, error) {
+		f, er := FindFile(db, target)
+		if er != nil {
+			return nil, ErrUnknownError.WithInternalMsg("FindFile failed, %v", er)
+		}
+		if f.IsZero() {
+			return nil, ErrFileRemoved.WithInternalMsg("link target %v is not found", target)
+		}
+		if f.Status != api.FileStatusPhysicDel {
+			return nil, nil
+		}
+		if err := restoreTrashedBlob(rail, target); err != nil {
+			return nil, err
+		}
+
+		// the linking file is restored right after, PhyDelFile won't remove the blob while it's linked
+		t := db.Exec("update file set status = ?, log_del_time = ?, phy_del_time = NULL, trash_time = NULL where file_id = ? and status = ?",
+			api.FileStatusLogicDel, time.Now(), target, api.FileStatusPhysicDel)
+		if t.Error != nil {
+			return nil, ErrUnknownError.WithInternalMsg("Failed to update file, %v", t.Error)
+		}
+		rail.Infof("Restored blob of link target %v", target)
+		return nil, nil
+	})
+	return e
+}
+
+// Restore files in batch, returns result of each file in order.
+func RestoreFiles(rail miso.Rail, db *gorm.DB, fileIds []string) ([]api.FileOpResult, error) {
+	return batchFileOp(rail, fileIds, func(fileId string) error { return RestoreFile(rail, db, fileId) })
+}
+
+// Schedule PurgeTrashTask on the task master node, files are not purged if retention is disabled, but the trash size
+// metrics are still updated.
+func SchedulePurgeTrashTask(rail miso.Rail) error {
+	if miso.GetPropInt(config.PropTrashRetention) < 1 {
+		rail.Info("Trash retention is disabled, files in trash dir are kept forever, PurgeTrashTask only updates the trash size metrics")
+	}
+	return task.ScheduleDistributedTask(miso.Job{
+		Name:       "PurgeTrashTask",
+		Cron:       miso.GetPropStr(config.PropTrashPurgeCron),
+		LogJobExec: true,
+		Run: func(rail miso.Rail) error {
+			return RunMaintenanceJob(rail, mysql.GetMySQL(), JobPurgeTrash)
+		},
+	})
+}
+
+type PurgeTrashResult struct {
+	DryRun        bool  `json:"dryRun" desc:"whether it's a dry-run, files are not removed"`
+	Purged        int   `json:"purged" desc:"number of files purged (or to be purged if it's a dry-run)"`
+	PurgedSize    int64 `json:"purgedSize" desc:"total size of files purged in bytes"`
+	Remaining     int   `json:"remaining" desc:"number of files remaining in trash dir"`
+	RemainingSize int64 `json:"remainingSize" desc:"total size of files remaining in trash dir in bytes"`
+}
+
+type trashedFile struct {
+	FileId    string
+	Status    string
+	TrashTime *util.ETime
+}
+
+// When the file is moved to trash, returns false if it's unknown.
+//
+// Files without record (e.g., moved by SanitizeStorage) fall back to the modification time, which is reset when they
+// are moved. Files trashed before trash_time is tracked are unknown, phy_del_time is not reliable (e.g., the 'direct'
+// strategy was used, or the file was moved back and forth), these files are never purged.
+func (f *trashedFile) trashedAt(modTime time.Time) (time.Time, bool) {
+	if f == nil {
+		return modTime, true
+	}
+	if f.TrashTime != nil {
+		return f.TrashTime.ToTime(), true
+	}
+	return time.Time{}, false
+}
+
+/*
+Permanently remove files in trash dir that are trashed before the retention period ('fstore.trash.retention' days).
+
+Files that are restored (i.e., the record is no longer PHY_DEL) or trashed before trash_time is tracked are never removed. If 'task.purge-trash-task.dry-run'
+is enabled, files to be purged are only logged. If retention is disabled, nothing is removed. The trash size metrics are
+updated on every run.
+*/
+func PurgeTrash(rail miso.Rail, db *gorm.DB) error {
+	return RunMaintenanceJob(rail, db, JobPurgeTrash)
+}
+
+func purgeTrash(rail miso.Rail, db *gorm.DB, p *JobProgress) error {
+	defer miso.TimeOp(rail, time.Now(), "PurgeTrash")
+
+	res := PurgeTrashResult{DryRun: miso.GetPropBool(config.PropPurgeTrashTaskDryRun)}
+	// the trash dir is still scanned when retention is disabled, so that the trash size metrics are updated
+	retention := miso.GetPropInt(config.PropTrashRetention)
+	disabled := retention < 1
+	if disabled {
+		rail.Info("Trash retention is disabled, only updating trash size metrics")
+	}
+	threshold := time.Now().Add(-time.Duration(retention) * 24 * time.Hour)
+
+	entries, err := os.ReadDir(miso.GetPropStr(config.PropTrashDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			trashSizeGauge.Set(0)
+			trashFilesGauge.Set(0)
+			return nil
+		}
+		return fmt.Errorf("failed to read dir, %v", err)
+	}
+	p.SetTotal(len(entries))
+
+	for len(entries) > 0 {
+		n := min(purgeTrashBatch, len(entries))
+		batch := entries[:n]
+		entries = entries[n:]
+
+		fileIds := make([]string, 0, len(batch))
+		for _, e := range batch {
+			if !e.IsDir() {
+				fileIds = append(fileIds, e.Name())
+			}
+		}
+		var records []trashedFile
+		if err := db.Raw("select file_id, status, trash_time from file where file_id in ?", fileIds).
+			Scan(&records).Error; err != nil {
+			return fmt.Errorf("failed to select trashed files, %v", err)
+		}
+		byId := make(map[string]*trashedFile, len(records))
+		for i := range records {
+			byId[records[i].FileId] = &records[i]
+		}
+
+		for _, e := range batch {
+			if p.Cancelled() {
+				return ErrJobCancelled
+			}
+			p.AddProcessed(1)
+			if e.IsDir() {
+				continue
+			}
+			fi, err := e.Info()
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return fmt.Errorf("failed to read file info, %v", err)
+			}
+			rec := byId[e.Name()]
+			trashedAt, known := rec.trashedAt(fi.ModTime())
+			if disabled || (rec != nil && rec.Status != api.FileStatusPhysicDel) || !known || trashedAt.After(threshold) {
+				res.Remaining++
+				res.RemainingSize += fi.Size()
+				continue
+			}
+
+			purged, err := purgeTrashedFile(rail, db, e.Name(), res.DryRun)
+			if err != nil {
+				rail.Errorf("Failed to purge trashed file %v, %v", e.Name(), err)
+				p.AddFailed(1)
+			}
+			if purged {
+				res.Purged++
+				res.PurgedSize += fi.Size()
+			} else {
+				res.Remaining++
+				res.RemainingSize += fi.Size()
+			}
+		}
+	}
+
+	size, files := res.RemainingSize, res.Remaining
+	if res.DryRun {
+		size, files = size+res.PurgedSize, files+res.Purged
+	}
+	trashSizeGauge.Set(float64(size))
+	trashFilesGauge.Set(float64(files))
+	rail.Infof("Purged trash, result: %+v", res)
+	return nil
+}
+
+// Remove the trashed file, the status is checked again while holding the file lock in case it's being restored.
+func purgeTrashedFile(rail miso.Rail, db *gorm.DB, fileId string, dryRun bool) (bool, error) {
+	return redis.RLockRun(rail, FileLockKey(fileId), func() (bool, error) {
+		f, err := FindFile(db, fileId)
+		if err != nil {
+			return false, fmt.Errorf("failed to find file, %v", err)
+		}
+		if !f.IsZero() && f.Status != api.FileStatusPhysicDel {
+			return false, nil
+		}
+
+		path := GenTrashPath(fileId)
+		if dryRun {
+			rail.Infof("Purging trash, (dry-run) will remove file %s", path)
+			return true, nil
+		}
+		if err := os.Remove(path); err != nil {
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		trashPurgedCounter.Inc()
+		rail.Infof("Purging trash, removed file %s", path)
+		return true, nil
+	})
+}
@@ -0,0 +1,61 @@
+package fstore
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+)
+
+func TestRestoreTrashedBlob(t *testing.T) {
+	miso.SetProp(config.PropStorageDir, t.TempDir())
+	miso.SetProp(config.PropTrashDir, t.TempDir())
+	c := miso.EmptyRail()
+
+	fileId := "file_9876543210"
+	if err := os.WriteFile(GenStoragePath(fileId), []byte("abc"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := (PDelFileTrashOp{}).delete(c, fileId); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreTrashedBlob(c, fileId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(GenStoragePath(fileId)); err != nil {
+		t.Fatalf("file is not restored, %v", err)
+	}
+
+	// already restored
+	if err := restoreTrashedBlob(c, fileId); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restoreTrashedBlob(c, "file_not_exist"); !errors.Is(err, ErrFileRemoved) {
+		t.Fatalf("expected ErrFileRemoved, got %v", err)
+	}
+}
+
+func TestTrashedAt(t *testing.T) {
+	modTime := time.Now().Add(-time.Hour)
+	var untracked *trashedFile
+	if v, ok := untracked.trashedAt(modTime); !ok || !v.Equal(modTime) {
+		t.Fatalf("expected %v, got %v, %v", modTime, v, ok)
+	}
+
+	// trashed before trash_time is tracked
+	f := &trashedFile{}
+	if v, ok := f.trashedAt(modTime); ok {
+		t.Fatalf("expected unknown, got %v", v)
+	}
+
+	trashed := util.Now().Add(-3 * time.Hour)
+	f.TrashTime = &trashed
+	if v, ok := f.trashedAt(modTime); !ok || !v.Equal(trashed.ToTime()) {
+		t.Fatalf("expected %v, got %v, %v", trashed, v, ok)
+	}
+}
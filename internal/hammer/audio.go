@@ -0,0 +1,185 @@
+package hammer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/mini-fstore/internal/fstore"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+)
+
+const (
+	waveformSampleRate = 8000 // audio is resampled to mono 8kHz before computing peaks
+	waveformWindow     = 80   // intermediate peaks are computed for every 80 samples (i.e., 100 peaks per second)
+	maxWaveformPeaks   = 10_000
+)
+
+func init() {
+	miso.SetDefProp(config.PropWaveformPeaks, 1000)
+}
+
+// Cover art and waveform generated and uploaded to mini-fstore.
+type GeneratedAudioPreview struct {
+	CoverArtFileId string // file id of the cover art, empty if the audio doesn't have any
+	WaveformFileId string // file id of the waveform json
+
+	Status string // api.ThumbnailStatus*
+	Error  string // reason of the failure
+}
+
+// Extract embedded cover art and compute waveform peaks of the audio, both are uploaded as mini-fstore files.
+func GenAudioPreview(rail miso.Rail, origin fstore.File, peaks int) (GeneratedAudioPreview, error) {
+	var gen GeneratedAudioPreview
+	stoPath, release, err := store.LocalPath(rail, origin)
+	if err != nil {
+		return gen, fmt.Errorf("failed to fetch audio, %w", err)
+	}
+	defer release()
+
+	md, err := ProbeVideo(rail, stoPath)
+	if err != nil {
+		return gen, fmt.Errorf("failed to probe audio, %w", err)
+	}
+
+	tmpDir := miso.GetPropStr(config.PropTempDir)
+
+	// embedded cover art is exposed by ffmpeg as a video stream with a single frame
+	if md.VideoCodec != "" {
+		cover := filepath.Join(tmpDir, util.RandNum(20)+"_cover.jpg")
+		defer os.Remove(cover)
+		if err := ExtractCoverArt(rail, stoPath, cover); err != nil {
+			rail.Warnf("Failed to extract cover art, fileId: %v, %v", origin.FileId, err)
+		} else if gen.CoverArtFileId, err = store.UploadLocalFile(rail, cover, origin.Name+"_cover.jpg"); err != nil {
+			return gen, fmt.Errorf("failed to upload local fstore file, %v", err)
+		}
+	}
+
+	if peaks < 1 {
+		peaks = miso.GetPropInt(config.PropWaveformPeaks)
+	}
+	wf, err := ComputeWaveform(rail, stoPath, min(peaks, maxWaveformPeaks))
+	if err != nil {
+		return gen, err
+	}
+	if wf.DurationMs < 1 {
+		wf.DurationMs = md.DurationMs
+	}
+
+	buf, err := json.Marshal(wf)
+	if err != nil {
+		return gen, fmt.Errorf("failed to marshal waveform, %v", err)
+	}
+	wfPath := filepath.Join(tmpDir, util.RandNum(20)+"_waveform.json")
+	defer os.Remove(wfPath)
+	if err := os.WriteFile(wfPath, buf, 0644); err != nil {
+		return gen, fmt.Errorf("failed to write waveform, %v", err)
+	}
+	if gen.WaveformFileId, err = store.UploadLocalFile(rail, wfPath, origin.Name+"_waveform.json"); err != nil {
+		return gen, fmt.Errorf("failed to upload local fstore file, %v", err)
+	}
+	rail.Infof("Generated audio preview for %v, %+v", origin.FileId, gen)
+	return gen, nil
+}
+
+// Extract the embedded cover art of the audio file (e.g., ID3 APIC in mp3, covr in m4a, PICTURE in flac) as jpeg.
+func ExtractCoverArt(rail miso.Rail, url string, output string) error {
+	args := []string{"-y", "-i", url, "-an", "-map", "0:v:0", "-frames:v", "1", output}
+	if _, err := RunProcess(rail, Process{Name: "ffmpeg", Args: args}); err != nil {
+		return fmt.Errorf("failed to extract cover art, url: %v, %w", url, err)
+	}
+	rail.Infof("ffmpeg extracted cover art of %v", url)
+	return nil
+}
+
+// Decode the audio using ffmpeg and compute the downsampled waveform peaks.
+func ComputeWaveform(rail miso.Rail, url string, peaks int) (api.Waveform, error) {
+	args := []string{"-v", "error", "-i", url, "-vn", "-ac", "1", "-ar", fmt.Sprint(waveformSampleRate),
+		"-f", "s16le", "-acodec", "pcm_s16le", "-"}
+
+	// the decoded samples are consumed while ffmpeg is running, they are never held in memory
+	pr, pw := io.Pipe()
+	var windows []float64
+	var samples int64
+	var readErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		windows, samples, readErr = computePcmPeaks(pr, waveformWindow)
+		io.Copy(io.Discard, pr) // make sure ffmpeg is never blocked
+	}()
+
+	_, err := RunProcess(rail, Process{Name: "ffmpeg", Args: args, Stdout: pw})
+	pw.CloseWithError(err)
+	<-done
+	if err != nil {
+		return api.Waveform{}, fmt.Errorf("failed to decode audio, url: %v, %w", url, err)
+	}
+	if readErr != nil {
+		return api.Waveform{}, fmt.Errorf("failed to read decoded audio, %v", readErr)
+	}
+	rail.Infof("ffmpeg decoded %v samples of %v", samples, url)
+
+	return api.Waveform{
+		DurationMs: samples * 1000 / waveformSampleRate,
+		Peaks:      downsamplePeaks(windows, peaks),
+	}, nil
+}
+
+// Read signed 16-bit little-endian mono samples, compute normalized peak (0-1) of each window.
+//
+// Returns the peaks and the total number of samples read.
+func computePcmPeaks(r io.Reader, window int) ([]float64, int64, error) {
+	br := bufio.NewReader(r)
+	peaks := make([]float64, 0, 1024)
+	var total int64
+	var n int
+	var peak float64
+	var sample [2]byte
+	for {
+		if _, err := io.ReadFull(br, sample[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return nil, total, err
+		}
+		v := math.Abs(float64(int16(binary.LittleEndian.Uint16(sample[:])))) / 32768
+		peak = math.Max(peak, v)
+		total++
+		if n++; n == window {
+			peaks = append(peaks, peak)
+			n, peak = 0, 0
+		}
+	}
+	if n > 0 {
+		peaks = append(peaks, peak)
+	}
+	return peaks, total, nil
+}
+
+// Downsample the peaks to at most n peaks, each peak is the max of the peaks merged, rounded to 3 decimals.
+func downsamplePeaks(peaks []float64, n int) []float64 {
+	if len(peaks) < n {
+		n = len(peaks)
+	}
+	ds := make([]float64, n)
+	for i := 0; i < n; i++ {
+		start := i * len(peaks) / n
+		end := (i + 1) * len(peaks) / n
+		var p float64
+		for _, v := range peaks[start:end] {
+			p = math.Max(p, v)
+		}
+		ds[i] = math.Round(p*1000) / 1000
+	}
+	return ds
+}
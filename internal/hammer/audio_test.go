@@ -0,0 +1,53 @@
+package hammer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestComputePcmPeaks(t *testing.T) {
+	samples := []int16{0, 100, -16384, 50, 32767, 0, -32768}
+	var buf bytes.Buffer
+	for _, s := range samples {
+		binary.Write(&buf, binary.LittleEndian, s)
+	}
+	buf.WriteByte(1) // incomplete sample is ignored
+
+	peaks, total, err := computePcmPeaks(&buf, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != int64(len(samples)) {
+		t.Fatalf("expected %v samples, got %v", len(samples), total)
+	}
+	want := []float64{0.5, 32767.0 / 32768, 1}
+	if len(peaks) != len(want) {
+		t.Fatalf("expected %v, got %v", want, peaks)
+	}
+	for i := range want {
+		if peaks[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, peaks)
+		}
+	}
+}
+
+func TestDownsamplePeaks(t *testing.T) {
+	ds := downsamplePeaks([]float64{0.1, 0.5, 0.2, 0.12345, 0.3, 0.9}, 3)
+	want := []float64{0.5, 0.2, 0.9}
+	if len(ds) != len(want) {
+		t.Fatalf("expected %v, got %v", want, ds)
+	}
+	for i := range want {
+		if ds[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, ds)
+		}
+	}
+
+	if ds := downsamplePeaks([]float64{0.1, 0.2}, 10); len(ds) != 2 {
+		t.Fatalf("expected 2 peaks, got %v", ds)
+	}
+	if ds := downsamplePeaks(nil, 10); len(ds) != 0 {
+		t.Fatalf("expected no peaks, got %v", ds)
+	}
+}
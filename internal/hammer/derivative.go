@@ -0,0 +1,96 @@
+package hammer
+
+import (
+	"fmt"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/fstore"
+	"github.com/curtisnewbie/miso/miso"
+)
+
+// Parameters of the rendition that identify the derived file.
+func renditionParams(r Rendition, extra string) string {
+	p := fmt.Sprintf("%dx%d_%s_q%d", r.Width, r.Height, r.Format, r.Quality)
+	if extra != "" {
+		p += "_" + extra
+	}
+	return p
+}
+
+// Generate thumbnails, renditions derived from the same content before are reused.
+//
+// gen is only called with the renditions that are not found, the newly generated ones are recorded as derivatives.
+func genCachedThumbnails(rail miso.Rail, origin fstore.File, operation string, extra string, renditions []Rendition,
+	gen func(missing []Rendition) (GeneratedThumbnails, error)) (GeneratedThumbnails, error) {
+
+	if len(renditions) < 1 {
+		rail.Warnf("No valid thumbnail preset or size is requested, fileId: %v (%v)", origin.FileId, operation)
+		return failedThumbnails(api.ThumbnailStatusInvalidSize, ErrNoThumbnailSize), nil
+	}
+
+	params := make([]string, 0, len(renditions))
+	for _, r := range renditions {
+		params = append(params, renditionParams(r, extra))
+	}
+
+	found, err := store.FindDerivatives(rail, origin.Sha1, operation, params)
+	if err != nil {
+		return GeneratedThumbnails{}, err
+	}
+
+	// the blob of the derivative is reused, but each requester gets its own file record, so that the
+	// thumbnail can be deleted without affecting the others
+	reused := make(map[string]string, len(found))
+	missing := make([]Rendition, 0, len(renditions))
+	for i, r := range renditions {
+		if derived, ok := found[params[i]]; ok {
+			fileId, err := store.LinkFile(rail, derived, thumbnailName(origin.Name, r.Name))
+			if err != nil {
+				return GeneratedThumbnails{}, err
+			}
+			if fileId != "" {
+				reused[r.Name] = fileId
+				continue
+			}
+		}
+		missing = append(missing, r)
+	}
+	if len(missing) < len(renditions) {
+		rail.Infof("Reusing %d derived renditions of %v (%v), %v to be generated", len(renditions)-len(missing),
+			origin.FileId, operation, len(missing))
+	}
+
+	var generated GeneratedThumbnails
+	if len(missing) > 0 {
+		generated, err = gen(missing)
+		if err != nil || generated.Status != api.ThumbnailStatusOk {
+			return generated, err
+		}
+		for _, r := range missing {
+			d := fstore.Derivative{
+				SourceSha1:   origin.Sha1,
+				SourceFileId: origin.FileId,
+				Operation:    operation,
+				Params:       renditionParams(r, extra),
+				FileId:       generated.Renditions[r.Name],
+			}
+			if err := store.SaveDerivative(rail, d); err != nil {
+				rail.Warnf("Failed to save derivative, %v", err)
+			}
+		}
+	}
+
+	// merge the reused ones and the generated ones, FileId is always the first rendition
+	merged := GeneratedThumbnails{Renditions: make(map[string]string, len(renditions)), Status: api.ThumbnailStatusOk}
+	for _, r := range renditions {
+		fileId, ok := reused[r.Name]
+		if !ok {
+			fileId = generated.Renditions[r.Name]
+		}
+		if merged.FileId == "" {
+			merged.FileId = fileId
+		}
+		merged.Renditions[r.Name] = fileId
+	}
+	return merged, nil
+}
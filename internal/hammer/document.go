@@ -0,0 +1,115 @@
+package hammer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+)
+
+const (
+	docPreviewMaxSize = 1024 // max width / height of the rendered page, thumbnails are resized from it
+)
+
+var (
+	ErrNotDocument = miso.NewErrf("File is not a supported document").WithCode(api.NotDocument)
+
+	officeFileExt = map[string]struct{}{
+		".doc":  {},
+		".docx": {},
+		".xls":  {},
+		".xlsx": {},
+		".ppt":  {},
+		".pptx": {},
+		".odt":  {},
+		".ods":  {},
+		".odp":  {},
+		".rtf":  {},
+	}
+)
+
+func init() {
+	miso.SetDefProp(config.PropDocPreviewPdfRenderer, "pdftoppm")
+	miso.SetDefProp(config.PropDocPreviewOfficeEnabled, false)
+	miso.SetDefProp(config.PropDocPreviewOfficeConverter, "soffice")
+}
+
+func isPdf(name string) bool {
+	return strings.ToLower(filepath.Ext(name)) == ".pdf"
+}
+
+func isOfficeDoc(name string) bool {
+	_, ok := officeFileExt[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// Check whether preview can be rendered for the document.
+//
+// Office documents are only supported when 'fstore.doc-preview.office.enabled' is true.
+func IsPreviewableDocument(name string) bool {
+	if isPdf(name) {
+		return true
+	}
+	return isOfficeDoc(name) && miso.GetPropBool(config.PropDocPreviewOfficeEnabled)
+}
+
+// Render first page of the document to a png image in dir, returns path of the rendered image.
+//
+// Office documents are converted to pdf using the locally installed converter (LibreOffice by default) first.
+func RenderDocumentFirstPage(rail miso.Rail, path string, name string, dir string) (string, error) {
+	if !IsPreviewableDocument(name) {
+		return "", ErrNotDocument
+	}
+
+	pdf := path
+	if !isPdf(name) {
+		var err error
+		if pdf, err = convertToPdf(rail, path, name, dir); err != nil {
+			return "", err
+		}
+	}
+
+	prefix := filepath.Join(dir, util.RandNum(20)+"_page")
+	renderer := miso.GetPropStr(config.PropDocPreviewPdfRenderer)
+	if err := runConverter(rail, renderer, pdfRenderArgs(pdf, prefix)...); err != nil {
+		return "", err
+	}
+	return prefix + ".png", nil
+}
+
+func pdfRenderArgs(pdf string, prefix string) []string {
+	return []string{"-png", "-f", "1", "-l", "1", "-singlefile", "-scale-to", strconv.Itoa(docPreviewMaxSize), pdf, prefix}
+}
+
+// Convert office document to pdf, the converted pdf is written to dir.
+func convertToPdf(rail miso.Rail, path string, name string, dir string) (string, error) {
+	// the converter names the output after the input, link the file with the original extension so the
+	// converter knows the input format
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve absolute path, %v", err)
+	}
+	in := filepath.Join(dir, util.RandNum(20)+strings.ToLower(filepath.Ext(name)))
+	if err := os.Symlink(abs, in); err != nil {
+		return "", fmt.Errorf("failed to link document to temp dir, %v", err)
+	}
+	converter := miso.GetPropStr(config.PropDocPreviewOfficeConverter)
+	if err := runConverter(rail, converter, "--headless", "--convert-to", "pdf", "--outdir", dir, in); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(in, filepath.Ext(in)) + ".pdf", nil
+}
+
+func runConverter(rail miso.Rail, name string, args ...string) error {
+	if _, err := RunProcess(rail, Process{Name: name, Args: args}); err != nil {
+		return err
+	}
+	rail.Infof("%v finished, args: %v", name, args)
+	return nil
+}
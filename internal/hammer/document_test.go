@@ -0,0 +1,27 @@
+package hammer
+
+import (
+	"testing"
+
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func TestIsPreviewableDocument(t *testing.T) {
+	miso.SetProp(config.PropDocPreviewOfficeEnabled, false)
+	if !IsPreviewableDocument("report.PDF") {
+		t.Fatal("pdf should be previewable")
+	}
+	if IsPreviewableDocument("report.docx") {
+		t.Fatal("office documents should not be previewable when disabled")
+	}
+	if IsPreviewableDocument("photo.jpg") {
+		t.Fatal("image is not a document")
+	}
+
+	miso.SetProp(config.PropDocPreviewOfficeEnabled, true)
+	defer miso.SetProp(config.PropDocPreviewOfficeEnabled, false)
+	if !IsPreviewableDocument("report.docx") {
+		t.Fatal("office documents should be previewable when enabled")
+	}
+}
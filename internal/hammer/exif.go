@@ -0,0 +1,245 @@
+package hammer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/mini-fstore/internal/fstore"
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"github.com/disintegration/gift"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+const (
+	jpegMarkerSOI   = 0xD8 // start of image
+	jpegMarkerSOS   = 0xDA // start of scan, followed by the entropy-coded data
+	jpegMarkerAPP1  = 0xE1 // EXIF, XMP
+	jpegMarkerAPP13 = 0xED // photoshop IRB, IPTC
+
+	exifHeader = "Exif\x00\x00"
+)
+
+var (
+	ErrNotJpeg = miso.NewErrf("File is not a jpeg image").WithCode(api.NotJpeg)
+)
+
+// Read EXIF orientation, 1 (normal) is returned if it's absent.
+func readOrientation(r io.Reader) int {
+	x, err := exif.Decode(r)
+	if err != nil {
+		return 1
+	}
+	return exifOrientation(x)
+}
+
+func exifOrientation(x *exif.Exif) int {
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+	o, err := tag.Int(0)
+	if err != nil || o < 1 || o > 8 {
+		return 1
+	}
+	return o
+}
+
+// Rotate or flip the image according to the EXIF orientation.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	var f gift.Filter
+	switch orientation {
+	case 2:
+		f = gift.FlipHorizontal()
+	case 3:
+		f = gift.Rotate180()
+	case 4:
+		f = gift.FlipVertical()
+	case 5:
+		f = gift.Transpose()
+	case 6:
+		f = gift.Rotate270() // gift rotates counter-clockwise
+	case 7:
+		f = gift.Transverse()
+	case 8:
+		f = gift.Rotate90()
+	default:
+		return img
+	}
+	g := gift.New(f)
+	dst := image.NewNRGBA(g.Bounds(img.Bounds()))
+	g.Draw(dst, img)
+	return dst
+}
+
+// Copy jpeg from r to w without EXIF, XMP and IPTC metadata, the image data is not re-encoded.
+//
+// EXIF orientation is preserved (as the only EXIF tag) so that the image is still displayed correctly.
+func StripJpegMetadata(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi[0] != 0xFF || soi[1] != jpegMarkerSOI {
+		return ErrNotJpeg
+	}
+	if _, err := bw.Write(soi[:]); err != nil {
+		return err
+	}
+
+	orientationWritten := false
+	for {
+		marker, err := readJpegMarker(br)
+		if err != nil {
+			return fmt.Errorf("failed to read jpeg marker, %w", err)
+		}
+
+		if marker == jpegMarkerSOS {
+			// the rest of the file is image data
+			if _, err := bw.Write([]byte{0xFF, marker}); err != nil {
+				return err
+			}
+			if _, err := io.Copy(bw, br); err != nil {
+				return err
+			}
+			return bw.Flush()
+		}
+
+		// markers without payload
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			if _, err := bw.Write([]byte{0xFF, marker}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var lb [2]byte
+		if _, err := io.ReadFull(br, lb[:]); err != nil {
+			return fmt.Errorf("failed to read jpeg segment length, %w", err)
+		}
+		n := int(binary.BigEndian.Uint16(lb[:]))
+		if n < 2 {
+			return fmt.Errorf("invalid jpeg segment length: %v", n)
+		}
+		payload := make([]byte, n-2)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return fmt.Errorf("failed to read jpeg segment, %w", err)
+		}
+
+		switch marker {
+		case jpegMarkerAPP1:
+			if !orientationWritten && bytes.HasPrefix(payload, []byte(exifHeader)) {
+				orientationWritten = true
+				if o := readOrientation(bytes.NewReader(payload)); o != 1 {
+					if _, err := bw.Write(orientationSegment(o)); err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		case jpegMarkerAPP13:
+			continue
+		}
+
+		if _, err := bw.Write([]byte{0xFF, marker, lb[0], lb[1]}); err != nil {
+			return err
+		}
+		if _, err := bw.Write(payload); err != nil {
+			return err
+		}
+	}
+}
+
+func readJpegMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, errors.New("invalid jpeg marker")
+	}
+	for b == 0xFF { // skip fill bytes
+		if b, err = br.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// APP1 segment with a minimal EXIF block that only contains the orientation tag.
+func orientationSegment(orientation int) []byte {
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, // big endian TIFF header
+		0x00, 0x00, 0x00, 0x08, // offset of IFD0
+		0x00, 0x01, // one entry
+		0x01, 0x12, // tag: orientation
+		0x00, 0x03, // type: SHORT
+		0x00, 0x00, 0x00, 0x01, // count
+		0x00, byte(orientation), 0x00, 0x00, // value
+		0x00, 0x00, 0x00, 0x00, // no next IFD
+	}
+	n := 2 + len(exifHeader) + len(tiff)
+	seg := []byte{0xFF, jpegMarkerAPP1, byte(n >> 8), byte(n)}
+	seg = append(seg, exifHeader...)
+	return append(seg, tiff...)
+}
+
+// Strip metadata from the jpeg file, a new file is uploaded and its fileId is returned.
+func StripImageMetadata(rail miso.Rail, fileId string) (string, error) {
+	origin, err := fstore.FindFile(mysql.GetMySQL(), fileId)
+	if err != nil {
+		return "", fmt.Errorf("failed to find fstore file info: %v, %v", fileId, err)
+	}
+	if origin.IsZero() {
+		return "", fstore.ErrFileNotFound
+	}
+	if origin.IsDeleted() {
+		return "", fstore.ErrFileDeleted
+	}
+
+	lname := strings.ToLower(origin.Name)
+	if !strings.HasSuffix(lname, ".jpg") && !strings.HasSuffix(lname, ".jpeg") {
+		return "", ErrNotJpeg
+	}
+
+	src, err := os.Open(origin.StoragePath())
+	if err != nil {
+		return "", fmt.Errorf("failed to open file, %v", err)
+	}
+	defer src.Close()
+
+	tmpPath := miso.GetPropStr(config.PropTempDir) + "/" + util.RandNum(20) + "_stripped"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file, %v", err)
+	}
+	defer os.Remove(tmpPath)
+	defer tmp.Close()
+
+	if err := StripJpegMetadata(src, tmp); err != nil {
+		if errors.Is(err, ErrNotJpeg) {
+			return "", err
+		}
+		return "", fmt.Errorf("failed to strip jpeg metadata, fileId: %v, %v", fileId, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to close temp file, %v", err)
+	}
+
+	newFileId, err := fstore.UploadLocalFile(rail, tmpPath, origin.Name)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload local fstore file, %v", err)
+	}
+	rail.Infof("Stripped metadata of %v, new file: %v", fileId, newFileId)
+	return newFileId, nil
+}
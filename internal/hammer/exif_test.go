@@ -0,0 +1,50 @@
+package hammer
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func TestStripJpegMetadata(t *testing.T) {
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, image.NewRGBA(image.Rect(0, 0, 20, 10)), nil); err != nil {
+		t.Fatal(err)
+	}
+	raw := enc.Bytes()
+
+	// SOI + EXIF (orientation) + IPTC + the rest
+	var in bytes.Buffer
+	in.Write(raw[:2])
+	in.Write(orientationSegment(6))
+	in.Write([]byte{0xFF, jpegMarkerAPP13, 0x00, 0x06, 'I', 'P', 'T', 'C'})
+	in.Write(raw[2:])
+
+	if o := readOrientation(bytes.NewReader(in.Bytes())); o != 6 {
+		t.Fatalf("expected orientation 6, got %v", o)
+	}
+
+	var out bytes.Buffer
+	if err := StripJpegMetadata(bytes.NewReader(in.Bytes()), &out); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out.Bytes(), []byte("IPTC")) {
+		t.Fatal("IPTC segment should be removed")
+	}
+	if o := readOrientation(bytes.NewReader(out.Bytes())); o != 6 {
+		t.Fatalf("orientation should be preserved, got %v", o)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := applyOrientation(img, 6)
+	if b := rotated.Bounds(); b.Dx() != 10 || b.Dy() != 20 {
+		t.Fatalf("image should be rotated, %v", b)
+	}
+
+	if err := StripJpegMetadata(bytes.NewReader([]byte("not a jpeg")), &out); err != ErrNotJpeg {
+		t.Fatalf("expected ErrNotJpeg, %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package hammer
+
+import (
+	"fmt"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/miso/encoding"
+	"github.com/curtisnewbie/miso/middleware/redis"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+)
+
+// Failed thumbnail generation that should not be retried.
+func failedThumbnails(status string, err error) GeneratedThumbnails {
+	return GeneratedThumbnails{Status: status, Error: util.MaxLenStr(err.Error(), 500)}
+}
+
+// Failed HLS transcoding that should not be retried.
+func failedHls(status string, err error) GeneratedHls {
+	return GeneratedHls{Status: status, Error: util.MaxLenStr(err.Error(), 500)}
+}
+
+// Failed audio preview generation that should not be retried.
+func failedAudioPreview(status string, err error) GeneratedAudioPreview {
+	return GeneratedAudioPreview{Status: status, Error: util.MaxLenStr(err.Error(), 500)}
+}
+
+// Key of attempt counter of the hammer job.
+func attemptKey(pipeline string, identifier string, fileId string) string {
+	return fmt.Sprintf("mini-fstore:hammer:attempt:%v:%v:%v", pipeline, identifier, fileId)
+}
+
+// Record an attempt of the hammer job that failed with retryable error.
+//
+// maxRetry is the MaxRetry(n) configured for the pipeline, miso drops the message once it's redelivered n times.
+// The redelivery count (header 'miso-rabbitmq-curr-retry') is not exposed to the listeners, so the attempts are
+// counted here as well. The counter doesn't expire, an attempt may take hours (e.g., HLS transcoding, or waiting for
+// other processes to finish), it's removed by clearAttempts once the job completes or here once it's dead-lettered.
+//
+// Returns true if this is the last attempt (the message is about to be dropped), in which case the event is
+// sent to api.HammerDeadLetterPipeline, and the caller should reply failure instead of returning the error.
+func lastAttemptFailed(rail miso.Rail, pipeline string, maxRetry int, identifier string, fileId string, evt any, cause error) bool {
+	key := attemptKey(pipeline, identifier, fileId)
+	cmd := redis.GetRedis().Incr(key)
+	if cmd.Err() != nil {
+		rail.Errorf("Failed to record attempt, key: %v, %v", key, cmd.Err())
+		return false
+	}
+
+	attempts := int(cmd.Val())
+	if attempts <= maxRetry {
+		rail.Warnf("Hammer job failed, will be retried, pipeline: %v, fileId: %v, attempts: %v, %v", pipeline, fileId, attempts, cause)
+		return false
+	}
+
+	rail.Errorf("Hammer job failed after %v attempts, sending to dead letter pipeline, pipeline: %v, fileId: %v, %v",
+		attempts, pipeline, fileId, cause)
+	payload, err := encoding.SWriteJson(evt)
+	if err != nil {
+		rail.Errorf("Failed to marshal event, %v", err)
+	}
+	dl := api.HammerDeadLetterEvent{
+		Pipeline: pipeline,
+		Payload:  payload,
+		Error:    util.MaxLenStr(cause.Error(), 500),
+		Attempts: attempts,
+	}
+	if err := api.HammerDeadLetterPipeline.Send(rail, dl); err != nil {
+		rail.Errorf("Failed to send dead letter event, %+v, %v", dl, err)
+	}
+	redis.GetRedis().Del(key)
+	return true
+}
+
+// Clear the attempt counter once the hammer job is completed.
+func clearAttempts(rail miso.Rail, pipeline string, identifier string, fileId string) {
+	if err := redis.GetRedis().Del(attemptKey(pipeline, identifier, fileId)).Err(); err != nil {
+		rail.Warnf("Failed to clear attempts, %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package hammer
+
+import (
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+)
+
+const (
+	maxFrameCandidates = 10
+	candidateWidth     = 160 // width of the candidate frames, they are only used for scoring
+	darkFrameLuma      = 20  // frames with mean luma below this are considered dark (e.g., fade-in, black screen)
+	brightFrameLuma    = 235 // frames with mean luma above this are considered blown-out (e.g., white screen)
+
+	previewFrames     = 10  // number of frames in the animated preview
+	previewWidth      = 320 // width of the animated preview
+	previewFrameDelay = 50  // delay between preview frames, in 100ths of a second
+)
+
+func init() {
+	miso.SetDefProp(config.PropVideoThumbnailSeek, "0")
+	miso.SetDefProp(config.PropVideoThumbnailCandidates, 1)
+}
+
+// Offset of the video thumbnail, either a percentage of the duration or an absolute offset in seconds.
+type FrameSeek struct {
+	Percent float64 // percentage of the duration (0-100)
+	Seconds float64 // offset in seconds, used when Percent is zero
+}
+
+// Resolve the offset in seconds, the offset is always within the duration (if known).
+func (s FrameSeek) Offset(durationMs int64) float64 {
+	dur := float64(durationMs) / 1000
+	var off float64
+	if s.Percent > 0 {
+		off = dur * s.Percent / 100
+	} else {
+		off = s.Seconds
+	}
+	if dur > 0 && off >= dur {
+		off = dur * 0.9 // seek too far, ffmpeg may not produce any frame at all
+	}
+	if off < 0 {
+		off = 0
+	}
+	return off
+}
+
+func (s FrameSeek) String() string {
+	if s.Percent > 0 {
+		return strconv.FormatFloat(s.Percent, 'f', -1, 64) + "%"
+	}
+	return strconv.FormatFloat(s.Seconds, 'f', -1, 64) + "s"
+}
+
+// Parse video thumbnail seek, e.g., "10%", "3", "3.5s".
+func ParseFrameSeek(v string) (FrameSeek, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if v == "" {
+		return FrameSeek{}, nil
+	}
+	if p, ok := strings.CutSuffix(v, "%"); ok {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || f < 0 || f > 100 {
+			return FrameSeek{}, fmt.Errorf("invalid seek percentage: '%v'", v)
+		}
+		return FrameSeek{Percent: f}, nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(v, "s")), 64)
+	if err != nil || f < 0 {
+		return FrameSeek{}, fmt.Errorf("invalid seek offset: '%v'", v)
+	}
+	return FrameSeek{Seconds: f}, nil
+}
+
+// Resolve video thumbnail seek and number of candidate frames.
+//
+// Properties 'fstore.thumbnail.video.seek' and 'fstore.thumbnail.video.candidates' are used if not specified.
+func ResolveFrameSeek(rail miso.Rail, seek string, candidates int) (FrameSeek, int) {
+	fs, err := ParseFrameSeek(seek)
+	if seek == "" || err != nil {
+		if err != nil {
+			rail.Warnf("%v, using 'fstore.thumbnail.video.seek'", err)
+		}
+		fs, err = ParseFrameSeek(miso.GetPropStr(config.PropVideoThumbnailSeek))
+		if err != nil {
+			rail.Warnf("Invalid 'fstore.thumbnail.video.seek', %v", err)
+		}
+	}
+	if candidates < 1 {
+		candidates = miso.GetPropInt(config.PropVideoThumbnailCandidates)
+	}
+	return fs, min(max(candidates, 1), maxFrameCandidates)
+}
+
+// Timestamps of the candidate frames, evenly spaced between the offset and the end of the video.
+func candidateOffsets(offset float64, durationMs int64, n int) []float64 {
+	dur := float64(durationMs) / 1000
+	if n < 2 || dur <= offset {
+		return []float64{offset}
+	}
+	step := (dur - offset) / float64(n)
+	offsets := make([]float64, 0, n)
+	for i := 0; i < n; i++ {
+		offsets = append(offsets, offset+step*float64(i))
+	}
+	return offsets
+}
+
+// Score the frame, frames that are neither too dark nor blown-out and with more details have higher scores.
+//
+// The score is the standard deviation of luma (i.e., contrast), dark or blown-out frames are heavily penalized.
+func scoreFrame(img image.Image) float64 {
+	b := img.Bounds()
+	n := float64(b.Dx() * b.Dy())
+	if n == 0 {
+		return 0
+	}
+
+	var sum, sumSq float64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			l := (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)) / 257
+			sum += l
+			sumSq += l * l
+		}
+	}
+	mean := sum / n
+	stddev := math.Sqrt(math.Max(sumSq/n-mean*mean, 0))
+	if mean < darkFrameLuma || mean > brightFrameLuma {
+		return stddev / 10
+	}
+	return stddev
+}
+
+// Pick the best frame among the candidates, returns the offset of the chosen frame.
+func PickBestFrame(rail miso.Rail, url string, offset float64, durationMs int64, candidates int) float64 {
+	offsets := candidateOffsets(offset, durationMs, candidates)
+	if len(offsets) < 2 {
+		return offset
+	}
+
+	best, bestScore := offset, -1.0
+	for _, off := range offsets {
+		tmp := "/tmp/" + util.RandNum(20) + "_candidate.png"
+		r := Rendition{ThumbnailSize: api.ThumbnailSize{Width: candidateWidth}, Output: tmp}
+		if err := ExtractFrameRenditions(rail, url, off, []Rendition{r}); err != nil {
+			rail.Warnf("Failed to extract candidate frame at %.3fs, %v", off, err)
+			os.Remove(tmp)
+			continue
+		}
+		img, err := decodeImageFile(tmp)
+		os.Remove(tmp)
+		if err != nil {
+			rail.Warnf("Failed to decode candidate frame at %.3fs, %v", off, err)
+			continue
+		}
+		score := scoreFrame(img)
+		rail.Debugf("Candidate frame at %.3fs, score: %.2f", off, score)
+		if score > bestScore {
+			best, bestScore = off, score
+		}
+	}
+	rail.Infof("Picked frame at %.3fs among %d candidates, score: %.2f", best, len(offsets), bestScore)
+	return best
+}
+
+// Generate animated GIF preview of the video, frames are sampled evenly across the whole video.
+func GenAnimatedPreview(rail miso.Rail, url string, durationMs int64, output string) error {
+	dur := float64(durationMs) / 1000
+	if dur <= 0 {
+		return fmt.Errorf("video duration unknown, unable to sample frames for preview")
+	}
+
+	step := dur / float64(previewFrames+1)
+	anim := &gif.GIF{}
+	for i := 1; i <= previewFrames; i++ {
+		tmp := "/tmp/" + util.RandNum(20) + "_preview.png"
+		r := Rendition{ThumbnailSize: api.ThumbnailSize{Width: previewWidth}, Output: tmp}
+		err := ExtractFrameRenditions(rail, url, step*float64(i), []Rendition{r})
+		if err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		img, err := decodeImageFile(tmp)
+		os.Remove(tmp)
+		if err != nil {
+			return fmt.Errorf("failed to decode preview frame, %v", err)
+		}
+		if len(anim.Image) > 0 && img.Bounds() != anim.Image[0].Bounds() {
+			continue // all frames in the GIF must have the same size
+		}
+		anim.Image = append(anim.Image, toPaletted(img))
+		anim.Delay = append(anim.Delay, previewFrameDelay)
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create preview file, filename: %v, %v", output, err)
+	}
+	defer f.Close()
+	if err := gif.EncodeAll(f, anim); err != nil {
+		return fmt.Errorf("failed to encode preview, %v", err)
+	}
+	return nil
+}
+
+func toPaletted(img image.Image) *image.Paletted {
+	b := img.Bounds()
+	p := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), palette.Plan9)
+	draw.FloydSteinberg.Draw(p, p.Bounds(), img, b.Min)
+	return p
+}
+
+func decodeImageFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// Name of the uploaded animated preview file.
+func previewName(originName string) string {
+	return originName + "_preview.gif"
+}
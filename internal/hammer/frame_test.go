@@ -0,0 +1,97 @@
+package hammer
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestParseFrameSeek(t *testing.T) {
+	cases := []struct {
+		v       string
+		want    FrameSeek
+		wantErr bool
+	}{
+		{v: "", want: FrameSeek{}},
+		{v: "10%", want: FrameSeek{Percent: 10}},
+		{v: " 33.5 % ", want: FrameSeek{Percent: 33.5}},
+		{v: "3", want: FrameSeek{Seconds: 3}},
+		{v: "3.5s", want: FrameSeek{Seconds: 3.5}},
+		{v: "101%", wantErr: true},
+		{v: "-1", wantErr: true},
+		{v: "abc", wantErr: true},
+	}
+	for _, c := range cases {
+		s, err := ParseFrameSeek(c.v)
+		if (err != nil) != c.wantErr {
+			t.Fatalf("%q, unexpected err: %v", c.v, err)
+		}
+		if err == nil && s != c.want {
+			t.Fatalf("%q, expected %+v, got %+v", c.v, c.want, s)
+		}
+	}
+
+	if s := (FrameSeek{Percent: 12.5}).String(); s != "12.5%" {
+		t.Fatalf("expected 12.5%%, got %v", s)
+	}
+	if s := (FrameSeek{Seconds: 3}).String(); s != "3s" {
+		t.Fatalf("expected 3s, got %v", s)
+	}
+
+	if off := (FrameSeek{Percent: 10}).Offset(60_000); off != 6 {
+		t.Fatalf("expected 6, got %v", off)
+	}
+	if off := (FrameSeek{Percent: 10}).Offset(0); off != 0 {
+		t.Fatalf("expected 0, got %v", off)
+	}
+	if off := (FrameSeek{Seconds: 30}).Offset(10_000); off != 9 {
+		t.Fatalf("expected 9, got %v", off)
+	}
+	if off := (FrameSeek{Seconds: 30}).Offset(0); off != 30 {
+		t.Fatalf("expected 30, got %v", off)
+	}
+}
+
+func TestCandidateOffsets(t *testing.T) {
+	offsets := candidateOffsets(2, 10_000, 4)
+	want := []float64{2, 4, 6, 8}
+	if len(offsets) != len(want) {
+		t.Fatalf("expected %v, got %v", want, offsets)
+	}
+	for i := range want {
+		if offsets[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, offsets)
+		}
+	}
+
+	if offsets := candidateOffsets(2, 0, 4); len(offsets) != 1 || offsets[0] != 2 {
+		t.Fatalf("expected [2], got %v", offsets)
+	}
+}
+
+func TestScoreFrame(t *testing.T) {
+	fill := func(f func(x, y int) color.Color) image.Image {
+		img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				img.Set(x, y, f(x, y))
+			}
+		}
+		return img
+	}
+
+	black := fill(func(x, y int) color.Color { return color.Black })
+	flat := fill(func(x, y int) color.Color { return color.Gray{Y: 128} })
+	dark := fill(func(x, y int) color.Color { return color.Gray{Y: uint8((x + y) % 2 * 30)} })
+	detailed := fill(func(x, y int) color.Color { return color.Gray{Y: uint8(40 + (x+y)%2*160)} })
+
+	if s := scoreFrame(black); s != 0 {
+		t.Fatalf("expected 0 for black frame, got %v", s)
+	}
+	if scoreFrame(flat) >= scoreFrame(detailed) {
+		t.Fatalf("flat frame should score lower than detailed frame")
+	}
+	if scoreFrame(dark) >= scoreFrame(detailed) {
+		t.Fatalf("dark frame should score lower than detailed frame")
+	}
+}
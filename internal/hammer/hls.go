@@ -0,0 +1,372 @@
+package hammer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/mini-fstore/internal/fstore"
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/miso"
+)
+
+const (
+	hlsPlaylistContentType = "application/vnd.apple.mpegurl"
+	hlsSegmentContentType  = "video/mp2t"
+	hlsAudioBitrate        = 128_000
+)
+
+var (
+	// Builtin HLS renditions, can be overriden in 'fstore.hls.renditions'.
+	DefHlsRenditions = []HlsRendition{
+		{Name: "360p", Height: 360, Bitrate: 800},
+		{Name: "720p", Height: 720, Bitrate: 2800},
+		{Name: "1080p", Height: 1080, Bitrate: 5000},
+	}
+
+	ErrNotHlsPlaylist = fstore.ErrNotHlsPlaylist
+)
+
+func init() {
+	miso.SetDefProp(config.PropHlsSegmentDuration, 6)
+}
+
+// HLS rendition to be transcoded.
+type HlsRendition struct {
+	Name    string // name of the rendition
+	Height  int    // height in pixels, width is scaled with the aspect ratio preserved
+	Bitrate int    // video bitrate in kbps
+}
+
+// Load HLS renditions from property 'fstore.hls.renditions', builtin renditions are used if absent.
+func LoadHlsRenditions() []HlsRendition {
+	if !miso.HasProp(config.PropHlsRenditions) {
+		return DefHlsRenditions
+	}
+	var conf []HlsRendition
+	miso.UnmarshalFromPropKey(config.PropHlsRenditions, &conf)
+	valid := make([]HlsRendition, 0, len(conf))
+	for _, r := range conf {
+		if r.Name == "" || r.Height < 1 || r.Bitrate < 1 {
+			continue
+		}
+		valid = append(valid, r)
+	}
+	if len(valid) < 1 {
+		return DefHlsRenditions
+	}
+	return valid
+}
+
+// Resolve the HLS renditions requested.
+//
+// Renditions taller than the source video are skipped (upscaling is pointless), but at least
+// the smallest rendition is always kept.
+func ResolveHlsRenditions(rail miso.Rail, names []string, srcHeight int) []HlsRendition {
+	conf := LoadHlsRenditions()
+	selected := conf
+	if len(names) > 0 {
+		selected = make([]HlsRendition, 0, len(names))
+		for _, r := range conf {
+			if slices.Contains(names, r.Name) {
+				selected = append(selected, r)
+			}
+		}
+		if len(selected) < 1 {
+			rail.Warnf("HLS renditions %v not found, using all renditions", names)
+			selected = conf
+		}
+	}
+
+	if srcHeight < 1 {
+		return selected
+	}
+	resolved := make([]HlsRendition, 0, len(selected))
+	smallest := selected[0]
+	for _, r := range selected {
+		if r.Height < smallest.Height {
+			smallest = r
+		}
+		if r.Height <= srcHeight {
+			resolved = append(resolved, r)
+		}
+	}
+	if len(resolved) < 1 {
+		resolved = append(resolved, smallest)
+	}
+	return resolved
+}
+
+// HLS playlists generated and uploaded to mini-fstore.
+type GeneratedHls struct {
+	PlaylistFileId string            // file id of the master playlist
+	Renditions     map[string]string // rendition name -> file id of the media playlist
+
+	Status string // api.ThumbnailStatus*
+	Error  string // reason of the failure
+}
+
+// Transcode the video to HLS renditions, the segments and playlists are uploaded as mini-fstore files.
+//
+// URIs in the uploaded playlists are replaced with the file ids of the segments and media playlists,
+// ServeHls rewrites them to URLs when the playlists are served. The segments and media playlists are
+// registered against the master playlist, ServeHls only serves the registered files.
+func TranscodeHls(rail miso.Rail, origin fstore.File, names []string) (GeneratedHls, error) {
+	stoPath, release, err := store.LocalPath(rail, origin)
+	if err != nil {
+		return GeneratedHls{}, fmt.Errorf("failed to fetch video, %w", err)
+	}
+	defer release()
+
+	md, err := ProbeVideo(rail, stoPath)
+	if err != nil {
+		return GeneratedHls{}, fmt.Errorf("failed to probe video, %w", err)
+	}
+	renditions := ResolveHlsRenditions(rail, names, md.Height)
+
+	dir, err := os.MkdirTemp(miso.GetPropStr(config.PropTempDir), "hls_")
+	if err != nil {
+		return GeneratedHls{}, fmt.Errorf("failed to create temp dir, %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	segDur := miso.GetPropInt(config.PropHlsSegmentDuration)
+	gen := GeneratedHls{Renditions: make(map[string]string, len(renditions))}
+	var files []api.HlsFile
+	var master strings.Builder
+	master.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, r := range renditions {
+		rdir := filepath.Join(dir, r.Name)
+		if err := os.MkdirAll(rdir, 0755); err != nil {
+			return GeneratedHls{}, fmt.Errorf("failed to create temp dir, %v", err)
+		}
+		if err := transcodeHlsRendition(rail, stoPath, rdir, r, segDur); err != nil {
+			return GeneratedHls{}, err
+		}
+		playlistId, uploaded, err := uploadHlsRendition(rail, origin.Name, rdir, r)
+		if err != nil {
+			return GeneratedHls{}, err
+		}
+		gen.Renditions[r.Name] = playlistId
+		files = append(files, uploaded...)
+
+		master.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d", r.Bitrate*1000+hlsAudioBitrate))
+		if md.Width > 0 && md.Height > 0 {
+			master.WriteString(fmt.Sprintf(",RESOLUTION=%dx%d", scaledWidth(md.Width, md.Height, r.Height), r.Height))
+		}
+		master.WriteString(fmt.Sprintf(",NAME=\"%s\"\n%s\n", r.Name, playlistId))
+	}
+
+	mp := filepath.Join(dir, "master.m3u8")
+	if err := os.WriteFile(mp, []byte(master.String()), 0644); err != nil {
+		return GeneratedHls{}, fmt.Errorf("failed to write master playlist, %v", err)
+	}
+	if gen.PlaylistFileId, err = store.UploadLocalFile(rail, mp, origin.Name+"_hls.m3u8"); err != nil {
+		return GeneratedHls{}, fmt.Errorf("failed to upload local fstore file, %v", err)
+	}
+	if err := store.SaveHlsFiles(rail, gen.PlaylistFileId, files); err != nil {
+		return GeneratedHls{}, fmt.Errorf("failed to save HLS files, %w", err)
+	}
+	rail.Infof("Video %v transcoded to HLS, master playlist: %v, renditions: %+v", origin.FileId, gen.PlaylistFileId, gen.Renditions)
+	return gen, nil
+}
+
+func transcodeHlsRendition(rail miso.Rail, url string, dir string, r HlsRendition, segDur int) error {
+	p := Process{Name: "ffmpeg", Args: hlsArgs(url, dir, r, segDur), Timeout: transcodeTimeout()}
+	if _, err := RunProcess(rail, p); err != nil {
+		return fmt.Errorf("failed to transcode url: %v, rendition: %+v, %w", url, r, err)
+	}
+	rail.Infof("ffmpeg transcoded %v to HLS rendition %v", url, r.Name)
+	return nil
+}
+
+func hlsArgs(url string, dir string, r HlsRendition, segDur int) []string {
+	return []string{
+		"-y", "-i", url,
+		"-vf", fmt.Sprintf("scale=-2:%d", r.Height),
+		"-c:v", "libx264", "-preset", "veryfast", "-profile:v", "main",
+		"-b:v", fmt.Sprintf("%dk", r.Bitrate),
+		"-maxrate", fmt.Sprintf("%dk", r.Bitrate*107/100),
+		"-bufsize", fmt.Sprintf("%dk", r.Bitrate*3/2),
+		"-g", strconv.Itoa(segDur * 30), "-sc_threshold", "0", // keyframe at each segment boundary (assuming 30fps)
+		"-c:a", "aac", "-b:a", "128k", "-ac", "2",
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(segDur),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(dir, "seg_%05d.ts"),
+		filepath.Join(dir, "index.m3u8"),
+	}
+}
+
+// Upload segments and the media playlist, returns file id of the media playlist, and all the files uploaded.
+func uploadHlsRendition(rail miso.Rail, originName string, dir string, r HlsRendition) (string, []api.HlsFile, error) {
+	playlist, err := os.ReadFile(filepath.Join(dir, "index.m3u8"))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read media playlist, %v", err)
+	}
+
+	var files []api.HlsFile
+	var err0 error
+	rewritten := rewritePlaylistUris(playlist, func(uri string) string {
+		if err0 != nil {
+			return uri
+		}
+		fileId, err := store.UploadLocalFile(rail, filepath.Join(dir, filepath.Base(uri)),
+			fmt.Sprintf("%s_hls_%s_%s", originName, r.Name, filepath.Base(uri)))
+		if err != nil {
+			err0 = fmt.Errorf("failed to upload local fstore file, %v", err)
+			return uri
+		}
+		files = append(files, api.HlsFile{Path: hlsFilePath(r.Name, filepath.Base(uri)), FileId: fileId})
+		return fileId
+	})
+	if err0 != nil {
+		return "", nil, err0
+	}
+
+	p := filepath.Join(dir, "index_uploaded.m3u8")
+	if err := os.WriteFile(p, rewritten, 0644); err != nil {
+		return "", nil, fmt.Errorf("failed to write media playlist, %v", err)
+	}
+	fileId, err := store.UploadLocalFile(rail, p, fmt.Sprintf("%s_hls_%s.m3u8", originName, r.Name))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to upload local fstore file, %v", err)
+	}
+	files = append(files, api.HlsFile{Path: hlsFilePath(r.Name, "index.m3u8"), FileId: fileId})
+	return fileId, files, nil
+}
+
+// Path of the HLS file in the transcoded output, e.g., '720p/index.m3u8'.
+func hlsFilePath(rendition string, name string) string {
+	return rendition + "/" + name
+}
+
+// Whether the registered HLS file is a media playlist, the others are segments.
+func isHlsPlaylist(path string) bool {
+	return strings.HasSuffix(path, ".m3u8")
+}
+
+// Replace each URI line in the playlist, tags and comments are kept as is.
+func rewritePlaylistUris(playlist []byte, f func(uri string) string) []byte {
+	var b bytes.Buffer
+	sc := bufio.NewScanner(bytes.NewReader(playlist))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			line = f(line)
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// URIs (file ids) referenced by the playlist.
+func playlistUris(playlist []byte) []string {
+	var uris []string
+	rewritePlaylistUris(playlist, func(uri string) string {
+		uris = append(uris, uri)
+		return uri
+	})
+	return uris
+}
+
+func scaledWidth(w, h, targetHeight int) int {
+	sw := int(math.Round(float64(w) * float64(targetHeight) / float64(h)))
+	return sw + sw%2
+}
+
+// Serve HLS master playlist, media playlists and segments.
+//
+// fileKey is the temporary file key (or signed file key) of the master playlist, child is the file id of the media playlist
+// or the segment registered against the master playlist (see fstore.SaveHlsFiles), or empty for the master playlist.
+// Only master playlists transcoded by hammer are served, the content of the playlists is never trusted for access control.
+// URIs in the served playlists are rewritten to relative URLs on the same endpoint with the same file key.
+func ServeHls(rail miso.Rail, w http.ResponseWriter, r *http.Request, fileKey string, child string) error {
+	_, master, err := fstore.ResolveFileAccess(rail, fileKey, fstore.NewFileAccess(r, api.FileOpStream))
+	if err != nil {
+		return err
+	}
+
+	// otherwise anyone holding the key of a crafted playlist can read any file
+	db := mysql.GetMySQL()
+	d, ok, err := fstore.FindHlsFile(db, master.FileId, child)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if child == "" {
+			return ErrNotHlsPlaylist.WithInternalMsg("%v is not transcoded by hammer", master.FileId)
+		}
+		return fstore.ErrFileNotFound.WithInternalMsg("%v is not registered against HLS playlist %v", child, master.FileId)
+	}
+
+	if child == "" {
+		content, err := os.ReadFile(master.StoragePath())
+		if err != nil {
+			return fmt.Errorf("failed to read master playlist, %v", err)
+		}
+		return servePlaylist(w, fileKey, content)
+	}
+
+	f, err := findHlsFile(child)
+	if err != nil {
+		return err
+	}
+	if isHlsPlaylist(d.Params) {
+		content, err := os.ReadFile(f.StoragePath())
+		if err != nil {
+			return fmt.Errorf("failed to read media playlist, %v", err)
+		}
+		return servePlaylist(w, fileKey, content)
+	}
+
+	headers := w.Header()
+	headers.Set("Content-Type", hlsSegmentContentType)
+	headers.Set("Content-Length", strconv.FormatInt(f.Size, 10))
+	return fstore.TransferWholeFile(rail, w, f.FileId)
+}
+
+func findHlsFile(fileId string) (fstore.File, error) {
+	f, err := fstore.FindFile(mysql.GetMySQL(), fileId)
+	if err != nil {
+		return f, err
+	}
+	if f.IsZero() {
+		return f, fstore.ErrFileNotFound
+	}
+	if f.IsDeleted() {
+		return f, fstore.ErrFileDeleted
+	}
+	return f, nil
+}
+
+func servePlaylist(w http.ResponseWriter, fileKey string, content []byte) error {
+	rewritten := rewritePlaylistUris(content, func(uri string) string {
+		return hlsUrl(fileKey, uri)
+	})
+	headers := w.Header()
+	headers.Set("Content-Type", hlsPlaylistContentType)
+	headers.Set("Content-Length", strconv.Itoa(len(rewritten)))
+	headers.Set("Cache-Control", "no-cache")
+	_, err := w.Write(rewritten)
+	return err
+}
+
+// Relative URL of the HLS endpoint, resolved by the player against the playlist URL.
+func hlsUrl(fileKey string, child string) string {
+	q := url.Values{}
+	q.Set("key", fileKey)
+	q.Set("f", child)
+	return "hls?" + q.Encode()
+}
@@ -0,0 +1,66 @@
+package hammer
+
+import (
+	"testing"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func TestRewritePlaylistUris(t *testing.T) {
+	playlist := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:6
+#EXTINF:6.000000,
+seg_00000.ts
+#EXTINF:2.500000,
+seg_00001.ts
+#EXT-X-ENDLIST
+`
+	rewritten := rewritePlaylistUris([]byte(playlist), func(uri string) string { return "file_" + uri })
+	uris := playlistUris(rewritten)
+	if len(uris) != 2 || uris[0] != "file_seg_00000.ts" || uris[1] != "file_seg_00001.ts" {
+		t.Fatalf("unexpected uris: %v", uris)
+	}
+
+	served := rewritePlaylistUris(rewritten, func(uri string) string { return hlsUrl("abc+/=", uri) })
+	uris = playlistUris(served)
+	if uris[0] != "hls?f=file_seg_00000.ts&key=abc%2B%2F%3D" {
+		t.Fatalf("unexpected uri: %v", uris[0])
+	}
+	t.Logf("%s", served)
+}
+
+func TestResolveHlsRenditions(t *testing.T) {
+	rail := miso.EmptyRail()
+
+	r := ResolveHlsRenditions(rail, nil, 720)
+	if len(r) != 2 || r[0].Name != "360p" || r[1].Name != "720p" {
+		t.Fatalf("unexpected renditions: %+v", r)
+	}
+
+	r = ResolveHlsRenditions(rail, nil, 240)
+	if len(r) != 1 || r[0].Name != "360p" {
+		t.Fatalf("unexpected renditions: %+v", r)
+	}
+
+	r = ResolveHlsRenditions(rail, []string{"1080p"}, 0)
+	if len(r) != 1 || r[0].Name != "1080p" {
+		t.Fatalf("unexpected renditions: %+v", r)
+	}
+
+	if w := scaledWidth(1920, 1080, 360); w != 640 {
+		t.Fatalf("expected 640, got %v", w)
+	}
+	if w := scaledWidth(1080, 1920, 720); w != 406 {
+		t.Fatalf("expected 406, got %v", w)
+	}
+}
+
+func TestHlsFilePath(t *testing.T) {
+	if p := hlsFilePath("720p", "index.m3u8"); p != "720p/index.m3u8" || !isHlsPlaylist(p) {
+		t.Fatalf("unexpected media playlist path: %v", p)
+	}
+	if p := hlsFilePath("720p", "seg_00000.ts"); p != "720p/seg_00000.ts" || isHlsPlaylist(p) {
+		t.Fatalf("unexpected segment path: %v", p)
+	}
+}
@@ -1,48 +1,152 @@
 package hammer
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"os"
+	"os/exec"
 
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/config"
 	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
 	"github.com/disintegration/gift"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
 	_ "golang.org/x/image/webp"
 )
 
+var (
+	ErrUnsupportedImageFormat = miso.NewErrf("Unsupported image format").WithCode(api.UnsupportedImageFormat)
+	ErrImageTooLarge          = miso.NewErrf("Image is too large to be processed").WithCode(api.ImageTooLarge)
+)
+
+func init() {
+	miso.SetDefProp(config.PropImageMaxPixels, 50_000_000)
+}
+
 func GiftCompressImage(rail miso.Rail, file string, output string) error {
+	return GiftResizeImage(rail, file, []Rendition{{ThumbnailSize: DefThumbnailSize, Output: output}})
+}
+
+// Resize image to all the renditions, the image is only decoded once.
+func GiftResizeImage(rail miso.Rail, file string, renditions []Rendition) error {
 	src, typ, err := loadImage(rail, file)
 	if err != nil {
-		return fmt.Errorf("failed to load image, filename: %v, %v", file, err)
+		return fmt.Errorf("failed to load image, filename: %v, %w", file, err)
 	}
 
-	imgFilter := gift.New(gift.ResizeToFit(512, 512, gift.LanczosResampling))
-	dst := image.NewNRGBA(imgFilter.Bounds(src.Bounds()))
-	imgFilter.Draw(dst, src)
-	if err := saveImage(rail, output, dst, typ); err != nil {
-		return fmt.Errorf("failed to save filtered image, file: %v, %v", output, err)
+	for _, r := range renditions {
+		imgFilter := gift.New(resizeFilter(r.ThumbnailSize))
+		dst := image.NewNRGBA(imgFilter.Bounds(src.Bounds()))
+		imgFilter.Draw(dst, src)
+		format := typ
+		if r.Format != "" {
+			format = r.Format
+		}
+		if err := saveImage(rail, r.Output, dst, format, r.Quality); err != nil {
+			return fmt.Errorf("failed to save filtered image, file: %v, %v", r.Output, err)
+		}
 	}
 	return nil
 }
 
+func resizeFilter(s api.ThumbnailSize) gift.Filter {
+	if s.Width > 0 && s.Height > 0 {
+		return gift.ResizeToFit(s.Width, s.Height, gift.LanczosResampling)
+	}
+	return gift.Resize(s.Width, s.Height, gift.LanczosResampling) // aspect ratio preserved when one of them is zero
+}
+
 func loadImage(rail miso.Rail, filename string) (image.Image, string, error) {
 	f, err := os.Open(filename)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to open image file, filename: %v, %v", filename, err)
 	}
 	defer f.Close()
+	if err := checkImagePixels(f, filename); err != nil {
+		return nil, "", err
+	}
 	img, typ, err := image.Decode(f)
 	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			// not a format registered, e.g., HEIC, try ffmpeg if it's available
+			return decodeWithFfmpeg(rail, filename)
+		}
 		rail.Errorf("image decode failed, filename: %v, %v", filename, err)
 		return nil, "", err
 	}
+
+	// photos taken by phones are usually rotated using EXIF orientation
+	if typ == "jpeg" {
+		if _, err := f.Seek(0, io.SeekStart); err == nil {
+			if o := readOrientation(f); o != 1 {
+				rail.Debugf("Applying EXIF orientation %v, filename: %v", o, filename)
+				img = applyOrientation(img, o)
+			}
+		}
+	}
 	return img, typ, nil
 }
 
-func saveImage(rail miso.Rail, filename string, img image.Image, typ string) error {
+// Check the dimensions of the image before it's decoded, a small but highly compressed image may take gigabytes of
+// memory once decoded.
+//
+// ErrImageTooLarge is returned if width * height exceeds 'fstore.image.max-pixels'. Images that can't be recognized
+// are left to the decoder, f is rewinded afterwards.
+func checkImagePixels(f io.ReadSeeker, filename string) error {
+	conf, _, err := image.DecodeConfig(f)
+	if _, serr := f.Seek(0, io.SeekStart); serr != nil {
+		return fmt.Errorf("failed to seek image file, filename: %v, %v", filename, serr)
+	}
+	if err != nil {
+		return nil
+	}
+	maxPixels := int64(miso.GetPropInt(config.PropImageMaxPixels))
+	if pixels := int64(conf.Width) * int64(conf.Height); maxPixels > 0 && pixels > maxPixels {
+		return ErrImageTooLarge.WithInternalMsg("filename: %v, %dx%d exceeds %d pixels", filename, conf.Width, conf.Height, maxPixels)
+	}
+	return nil
+}
+
+// Decode image using ffmpeg, the image is converted to png first.
+//
+// ErrUnsupportedImageFormat is returned if ffmpeg is not available or ffmpeg can't decode it either.
+func decodeWithFfmpeg(rail miso.Rail, filename string) (image.Image, string, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, "", ErrUnsupportedImageFormat.WithInternalMsg("ffmpeg not available, filename: %v", filename)
+	}
+
+	tmp := miso.GetPropStr(config.PropTempDir) + "/" + util.RandNum(20) + "_decoded.png"
+	defer os.Remove(tmp)
+	args := []string{"-v", "error", "-y", "-i", filename, "-frames:v", "1", tmp}
+	if _, err := RunProcess(rail, Process{Name: "ffmpeg", Args: args}); err != nil {
+		return nil, "", ErrUnsupportedImageFormat.WithInternalMsg("ffmpeg failed to decode %v, %v", filename, err)
+	}
+
+	f, err := os.Open(tmp)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open decoded image, %v", err)
+	}
+	defer f.Close()
+	if err := checkImagePixels(f, filename); err != nil {
+		return nil, "", err
+	}
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image converted by ffmpeg, %v", err)
+	}
+	rail.Infof("Decoded %v using ffmpeg", filename)
+	return img, "png", nil
+}
+
+func saveImage(rail miso.Rail, filename string, img image.Image, typ string, quality int) error {
 	f, err := os.Create(filename)
 	if err != nil {
 		return fmt.Errorf("failed to create image file, filename: %v, %v", filename, err)
@@ -51,19 +155,40 @@ func saveImage(rail miso.Rail, filename string, img image.Image, typ string) err
 
 	rail.Infof("image type: %v, %v", filename, typ)
 
+	if err := encodeImage(f, img, typ, quality); err != nil {
+		return fmt.Errorf("image encode failed, filename: %v, %v", filename, err)
+	}
+	return nil
+}
+
+// Encode image in the given format, png is used for unsupported formats.
+//
+// quality is only used by jpeg, zero means the default quality. webp is always encoded losslessly.
+func encodeImage(w io.Writer, img image.Image, typ string, quality int) error {
 	switch typ {
 	case "png":
-		err = png.Encode(f, img)
+		return png.Encode(w, img)
 	case "jpeg":
-		err = jpeg.Encode(f, img, nil)
+		var opt *jpeg.Options
+		if quality > 0 {
+			opt = &jpeg.Options{Quality: quality}
+		}
+		return jpeg.Encode(w, img, opt)
 	case "gif":
-		err = gif.Encode(f, img, nil)
+		return gif.Encode(w, img, nil)
+	case "webp":
+		return nativewebp.Encode(w, img, nil)
 	default:
-		err = png.Encode(f, img)
+		return png.Encode(w, img)
 	}
+}
 
-	if err != nil {
-		return fmt.Errorf("image encode failed, filename: %v, %v", filename, err)
+// Format actually used by encodeImage.
+func encodedFormat(typ string) string {
+	switch typ {
+	case "png", "jpeg", "gif", "webp":
+		return typ
+	default:
+		return "png"
 	}
-	return nil
 }
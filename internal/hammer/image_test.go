@@ -1,9 +1,18 @@
 package hammer
 
 import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/curtisnewbie/mini-fstore/internal/config"
 	"github.com/curtisnewbie/miso/miso"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
 )
 
 func TestGiftCompressImage(t *testing.T) {
@@ -14,3 +23,81 @@ func TestGiftCompressImage(t *testing.T) {
 		t.Fatal(e)
 	}
 }
+
+func TestEncodeImageWebp(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, src, "webp", 0); err != nil {
+		t.Fatal(err)
+	}
+	_, typ, err := image.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != "webp" {
+		t.Fatalf("expected webp, got %v", typ)
+	}
+}
+
+func TestLoadImageFormats(t *testing.T) {
+	dir := t.TempDir()
+	miso.SetProp(config.PropTempDir, dir)
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+
+	var b bytes.Buffer
+	if err := bmp.Encode(&b, src); err != nil {
+		t.Fatal(err)
+	}
+	var tf bytes.Buffer
+	if err := tiff.Encode(&tf, src, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string][]byte{"test.bmp": b.Bytes(), "test.tiff": tf.Bytes()} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		img, _, err := loadImage(miso.EmptyRail(), p)
+		if err != nil {
+			t.Fatalf("%v, %v", name, err)
+		}
+		if img.Bounds().Dx() != 16 || img.Bounds().Dy() != 8 {
+			t.Fatalf("%v, unexpected bounds: %v", name, img.Bounds())
+		}
+	}
+
+	p := filepath.Join(dir, "test.heic")
+	if err := os.WriteFile(p, []byte("definitely not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := loadImage(miso.EmptyRail(), p)
+	if !errors.Is(err, ErrUnsupportedImageFormat) {
+		t.Fatalf("expected ErrUnsupportedImageFormat, got %v", err)
+	}
+	if errors.Is(err, ErrInvalidMedia) || errors.Is(err, ErrNotImage) || errors.Is(err, ErrNotJpeg) {
+		t.Fatalf("ErrUnsupportedImageFormat should not match other format errors, %v", err)
+	}
+}
+
+func TestLoadImageMaxPixels(t *testing.T) {
+	miso.SetProp(config.PropImageMaxPixels, 100)
+	defer miso.SetProp(config.PropImageMaxPixels, 50_000_000)
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewRGBA(image.Rect(0, 0, 20, 20))); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(t.TempDir(), "large.png")
+	if err := os.WriteFile(p, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadImage(miso.EmptyRail(), p); !errors.Is(err, ErrImageTooLarge) {
+		t.Fatalf("expected ErrImageTooLarge, got %v", err)
+	}
+
+	miso.SetProp(config.PropImageMaxPixels, 400)
+	if _, _, err := loadImage(miso.EmptyRail(), p); err != nil {
+		t.Fatal(err)
+	}
+}
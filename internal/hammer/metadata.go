@@ -0,0 +1,132 @@
+package hammer
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/curtisnewbie/mini-fstore/internal/fstore"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+func ListenExtractMetadataEvent(rail miso.Rail, evt fstore.ExtractMetadataEvent) error {
+	origin, err := store.FindFile(rail, evt.FileId)
+	if err != nil {
+		return fmt.Errorf("failed to find fstore file info: %v, %v", evt.FileId, err)
+	}
+	if fileMissing(origin) {
+		rail.Warnf("fstore file %v is not found or deleted", evt.FileId)
+		return nil
+	}
+
+	mediaType := fstore.GuessMediaType(origin.Name)
+	if mediaType == "" {
+		return nil
+	}
+
+	path, release, err := store.LocalPath(rail, origin)
+	if err != nil {
+		if errors.Is(err, ErrFileTooLarge) {
+			rail.Warnf("Failed to extract media metadata, file is too large, giving up, fileId: %v, %v", evt.FileId, err)
+			return nil
+		}
+		return err
+	}
+	defer release()
+
+	var md fstore.FileMetadata
+	if mediaType == fstore.MediaTypeImage {
+		md, err = ExtractImageMetadata(rail, path, origin.Name)
+	} else {
+		md, err = ProbeVideo(rail, path)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidMedia), errors.Is(err, ErrUnsupportedImageFormat):
+			// the file may be corrupted or simply not a media file, retrying doesn't help
+			rail.Warnf("Failed to extract media metadata, not a valid media file, giving up, fileId: %v, %v", evt.FileId, err)
+			return nil
+		case errors.Is(err, ErrProbeUnavailable):
+			rail.Errorf("Failed to extract media metadata, ffprobe is not installed, giving up, fileId: %v, %v", evt.FileId, err)
+			return nil
+		}
+		return fmt.Errorf("failed to extract media metadata, fileId: %v, %w", evt.FileId, err)
+	}
+	md.FileId = evt.FileId
+	return store.SaveFileMetadata(rail, md)
+}
+
+// Extract image metadata without decoding the whole image.
+//
+// Width and height are the displayed dimensions with EXIF orientation applied. name is the original filename, its
+// extension is used as the format if the image is only decodable by ffmpeg, path may not have an extension at all.
+func ExtractImageMetadata(rail miso.Rail, path string, name string) (fstore.FileMetadata, error) {
+	var md fstore.FileMetadata
+	f, err := os.Open(path)
+	if err != nil {
+		return md, fmt.Errorf("failed to open image file, path: %v, %v", path, err)
+	}
+	defer f.Close()
+
+	conf, typ, err := image.DecodeConfig(f)
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			// not a format registered, e.g., HEIC, decode it using ffmpeg like the thumbnails
+			return extractImageMetadataWithFfmpeg(rail, path, name)
+		}
+		return md, fmt.Errorf("failed to decode image config, path: %v, %v", path, err)
+	}
+	md.Format = typ
+	md.Width = conf.Width
+	md.Height = conf.Height
+
+	if typ != "jpeg" {
+		return md, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return md, fmt.Errorf("failed to seek image file, path: %v, %v", path, err)
+	}
+	x, err := exif.Decode(f)
+	if err != nil {
+		rail.Debugf("EXIF not found, path: %v, %v", path, err)
+		return md, nil
+	}
+
+	if o := exifOrientation(x); o >= 5 { // rotated by 90 or 270 degree
+		md.Width, md.Height = md.Height, md.Width
+	}
+	if t, err := x.DateTime(); err == nil {
+		et := util.ToETime(t)
+		md.CaptureTime = &et
+	}
+	if tag, err := x.Get(exif.Model); err == nil {
+		if model, err := tag.StringVal(); err == nil {
+			md.CameraModel = util.MaxLenStr(strings.TrimSpace(model), 128)
+		}
+	}
+	return md, nil
+}
+
+// Extract dimensions of image that is only decodable by ffmpeg, the format is guessed using the extension of name.
+func extractImageMetadataWithFfmpeg(rail miso.Rail, path string, name string) (fstore.FileMetadata, error) {
+	var md fstore.FileMetadata
+	img, _, err := decodeWithFfmpeg(rail, path)
+	if err != nil {
+		return md, err
+	}
+	md.Format = imageFormatOf(name)
+	md.Width = img.Bounds().Dx()
+	md.Height = img.Bounds().Dy()
+	return md, nil
+}
+
+// Image format guessed using the extension of the filename, e.g., 'heic'.
+func imageFormatOf(name string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+}
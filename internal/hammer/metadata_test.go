@@ -0,0 +1,47 @@
+package hammer
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func TestExtractImageMetadata(t *testing.T) {
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, image.NewRGBA(image.Rect(0, 0, 40, 30)), nil); err != nil {
+		t.Fatal(err)
+	}
+	raw := enc.Bytes()
+	var buf bytes.Buffer
+	buf.Write(raw[:2])
+	buf.Write(orientationSegment(6))
+	buf.Write(raw[2:])
+
+	p := filepath.Join(t.TempDir(), "test.jpg")
+	if err := os.WriteFile(p, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := ExtractImageMetadata(miso.EmptyRail(), p, "photo.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Format != "jpeg" || md.Width != 30 || md.Height != 40 {
+		t.Fatalf("incorrect metadata, %+v", md)
+	}
+	t.Logf("%+v", md)
+}
+
+func TestImageFormatOf(t *testing.T) {
+	if f := imageFormatOf("IMG_0001.HEIC"); f != "heic" {
+		t.Fatalf("expected heic, got %v", f)
+	}
+	if f := imageFormatOf("file_123"); f != "" {
+		t.Fatalf("expected empty format, got %v", f)
+	}
+}
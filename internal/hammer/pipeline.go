@@ -1,20 +1,30 @@
 package hammer
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/config"
 	"github.com/curtisnewbie/mini-fstore/internal/fstore"
-	"github.com/curtisnewbie/miso/middleware/mysql"
 	"github.com/curtisnewbie/miso/middleware/rabbit"
 	"github.com/curtisnewbie/miso/miso"
 	"github.com/curtisnewbie/miso/util"
 )
 
 func InitPipeline(rail miso.Rail) error {
+	if miso.GetPropBool(config.PropHammerRemoteAccess) {
+		store = remoteFileStore{service: miso.GetPropStr(config.PropHammerRemoteService)}
+		rail.Infof("Hammer fetches and stores files through mini-fstore HTTP API, service: %v", miso.GetPropStr(config.PropHammerRemoteService))
+	}
+
 	api.GenImgThumbnailPipeline.Listen(3, ListenCompressImageEvent)
 	api.GenVidThumbnailPipeline.Listen(3, ListenGenVideoThumbnailEvent)
+	api.GenDocThumbnailPipeline.Listen(2, ListenGenDocThumbnailEvent)
+	api.TranscodeHlsPipeline.Listen(1, ListenTranscodeHlsEvent)
+	api.GenAudioPreviewPipeline.Listen(2, ListenGenAudioPreviewEvent)
+	fstore.ExtractMetadataPipeline.Listen(2, ListenExtractMetadataEvent)
 	return nil
 }
 
@@ -26,14 +36,25 @@ func ListenCompressImageEvent(rail miso.Rail, evt api.ImgThumbnailTriggerEvent)
 		return nil
 	}
 
-	generatedFileId, err := GenImageThumbnail(rail, evt)
+	pipeline := api.GenImgThumbnailPipeline.Name()
+	generated, err := GenImageThumbnail(rail, evt)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, ErrFileTooLarge):
+			rail.Warnf("File is too large, giving up, fileId: %v, %v", evt.FileId, err)
+			generated = failedThumbnails(api.ThumbnailStatusFileTooLarge, err)
+		case lastAttemptFailed(rail, pipeline, api.HammerMaxRetry, evt.Identifier, evt.FileId, evt, err):
+			generated = failedThumbnails(api.ThumbnailStatusRetryExhausted, err)
+		default:
+			return err
+		}
 	}
+	clearAttempts(rail, pipeline, evt.Identifier, evt.FileId)
 
 	// reply to the specified event bus
 	return rabbit.PubEventBus(rail,
-		api.ImageCompressReplyEvent{Identifier: evt.Identifier, FileId: generatedFileId},
+		api.ImageCompressReplyEvent{Identifier: evt.Identifier, FileId: generated.FileId, Renditions: generated.Renditions,
+			Status: generated.Status, Error: generated.Error},
 		evt.ReplyTo)
 }
 
@@ -45,79 +66,420 @@ func ListenGenVideoThumbnailEvent(rail miso.Rail, evt api.VidThumbnailTriggerEve
 		return nil
 	}
 
-	generatedFileId, err := GenVideoThumbnail(rail, evt)
+	pipeline := api.GenVidThumbnailPipeline.Name()
+	generated, err := GenVideoThumbnail(rail, evt)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrFileTooLarge):
+			rail.Warnf("File is too large, giving up, fileId: %v, %v", evt.FileId, err)
+			generated = failedThumbnails(api.ThumbnailStatusFileTooLarge, err)
+		case lastAttemptFailed(rail, pipeline, api.HammerMaxRetry, evt.Identifier, evt.FileId, evt, err):
+			generated = failedThumbnails(api.ThumbnailStatusRetryExhausted, err)
+		default:
+			return err
+		}
+	}
+	clearAttempts(rail, pipeline, evt.Identifier, evt.FileId)
+
+	// reply to the specified event bus
+	return rabbit.PubEventBus(rail,
+		api.GenVideoThumbnailReplyEvent{Identifier: evt.Identifier, FileId: generated.FileId, Renditions: generated.Renditions,
+			PreviewFileId: generated.PreviewFileId, Status: generated.Status, Error: generated.Error},
+		evt.ReplyTo)
+}
+
+func ListenGenDocThumbnailEvent(rail miso.Rail, evt api.DocThumbnailTriggerEvent) error {
+	rail.Infof("Received %#v", evt)
+
+	if evt.ReplyTo == "" {
+		rail.Errorf("replyTo is empty, %#v", evt)
+		return nil
+	}
+
+	pipeline := api.GenDocThumbnailPipeline.Name()
+	generated, err := GenDocThumbnail(rail, evt)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, ErrFileTooLarge):
+			rail.Warnf("File is too large, giving up, fileId: %v, %v", evt.FileId, err)
+			generated = failedThumbnails(api.ThumbnailStatusFileTooLarge, err)
+		case lastAttemptFailed(rail, pipeline, api.HammerMaxRetry, evt.Identifier, evt.FileId, evt, err):
+			generated = failedThumbnails(api.ThumbnailStatusRetryExhausted, err)
+		default:
+			return err
+		}
 	}
+	clearAttempts(rail, pipeline, evt.Identifier, evt.FileId)
 
 	// reply to the specified event bus
 	return rabbit.PubEventBus(rail,
-		api.GenVideoThumbnailReplyEvent{Identifier: evt.Identifier, FileId: generatedFileId},
+		api.GenDocThumbnailReplyEvent{Identifier: evt.Identifier, FileId: generated.FileId, Renditions: generated.Renditions,
+			Status: generated.Status, Error: generated.Error},
 		evt.ReplyTo)
 }
 
-func GenImageThumbnail(rail miso.Rail, evt api.ImgThumbnailTriggerEvent) (string, error) {
-	origin, err := fstore.FindFile(mysql.GetMySQL(), evt.FileId)
+func ListenTranscodeHlsEvent(rail miso.Rail, evt api.TranscodeHlsTriggerEvent) error {
+	rail.Infof("Received %#v", evt)
+
+	if evt.ReplyTo == "" {
+		rail.Errorf("replyTo is empty, %#v", evt)
+		return nil
+	}
+
+	pipeline := api.TranscodeHlsPipeline.Name()
+	generated, err := handleTranscodeHls(rail, evt)
 	if err != nil {
-		return "", fmt.Errorf("failed to find fstore file info: %v, %v", evt.FileId, err)
+		switch {
+		case errors.Is(err, ErrFileTooLarge):
+			rail.Warnf("File is too large, giving up, fileId: %v, %v", evt.FileId, err)
+			generated = failedHls(api.ThumbnailStatusFileTooLarge, err)
+		case lastAttemptFailed(rail, pipeline, api.TranscodeHlsMaxRetry, evt.Identifier, evt.FileId, evt, err):
+			generated = failedHls(api.ThumbnailStatusRetryExhausted, err)
+		default:
+			return err
+		}
 	}
+	clearAttempts(rail, pipeline, evt.Identifier, evt.FileId)
 
-	if origin.Id < 1 || origin.IsDeleted() {
+	// reply to the specified event bus
+	return rabbit.PubEventBus(rail,
+		api.TranscodeHlsReplyEvent{Identifier: evt.Identifier, FileId: evt.FileId, PlaylistFileId: generated.PlaylistFileId,
+			Renditions: generated.Renditions, Status: generated.Status, Error: generated.Error},
+		evt.ReplyTo)
+}
+
+func handleTranscodeHls(rail miso.Rail, evt api.TranscodeHlsTriggerEvent) (GeneratedHls, error) {
+	origin, err := store.FindFile(rail, evt.FileId)
+	if err != nil {
+		return GeneratedHls{}, fmt.Errorf("failed to find fstore file info: %v, %v", evt.FileId, err)
+	}
+	if fileMissing(origin) {
 		rail.Warnf("fstore file %v is not found or deleted, %v", evt.FileId, evt.Identifier)
-		return "", nil
+		return failedHls(api.ThumbnailStatusFileNotFound, fstore.ErrFileNotFound), nil
 	}
 
-	// compress the origin image, if the compression failed, we just give up
-	tmpPath := "/tmp/" + util.RandNum(20) + "_compressed"
-	defer os.Remove(tmpPath)
+	generated, err := TranscodeHls(rail, origin, evt.Renditions)
+	if err != nil {
+		if errors.Is(err, ErrFileTooLarge) {
+			return GeneratedHls{}, err
+		}
+		if errors.Is(err, ErrInvalidMedia) {
+			rail.Warnf("Failed to transcode video to HLS, not a valid media file, giving up, fileId: %v, %v", evt.FileId, err)
+		} else {
+			rail.Errorf("Failed to transcode video to HLS, giving up, fileId: %v, %v", evt.FileId, err)
+		}
+		return failedHls(api.ThumbnailStatusProcessingFailed, err), nil
+	}
+	generated.Status = api.ThumbnailStatusOk
+	return generated, nil
+}
 
-	stoPath := origin.StoragePath()
-	if err := GiftCompressImage(rail, stoPath, tmpPath); err != nil {
-		rail.Errorf("Failed to generate image thumbnail, giving up, fileid: %v, path: %v, %v", evt.FileId, stoPath, err)
-		return "", nil
+func ListenGenAudioPreviewEvent(rail miso.Rail, evt api.AudioPreviewTriggerEvent) error {
+	rail.Infof("Received %#v", evt)
+
+	if evt.ReplyTo == "" {
+		rail.Errorf("replyTo is empty, %#v", evt)
+		return nil
 	}
 
-	rail.Infof("Image %v compressed to %v", evt.Identifier, tmpPath)
+	pipeline := api.GenAudioPreviewPipeline.Name()
+	generated, err := handleGenAudioPreview(rail, evt)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrFileTooLarge):
+			rail.Warnf("File is too large, giving up, fileId: %v, %v", evt.FileId, err)
+			generated = failedAudioPreview(api.ThumbnailStatusFileTooLarge, err)
+		case lastAttemptFailed(rail, pipeline, api.HammerMaxRetry, evt.Identifier, evt.FileId, evt, err):
+			generated = failedAudioPreview(api.ThumbnailStatusRetryExhausted, err)
+		default:
+			return err
+		}
+	}
+	clearAttempts(rail, pipeline, evt.Identifier, evt.FileId)
 
-	// upload the compressed image to mini-fstore
-	tmpName := origin.Name + "_thumbnail"
-	uploadFileId, err := fstore.UploadLocalFile(rail, tmpPath, tmpName)
+	// reply to the specified event bus
+	return rabbit.PubEventBus(rail,
+		api.AudioPreviewReplyEvent{Identifier: evt.Identifier, FileId: evt.FileId, CoverArtFileId: generated.CoverArtFileId,
+			WaveformFileId: generated.WaveformFileId, Status: generated.Status, Error: generated.Error},
+		evt.ReplyTo)
+}
+
+func handleGenAudioPreview(rail miso.Rail, evt api.AudioPreviewTriggerEvent) (GeneratedAudioPreview, error) {
+	origin, err := store.FindFile(rail, evt.FileId)
 	if err != nil {
-		return "", fmt.Errorf("failed to upload local fstore file, %v", err)
+		return GeneratedAudioPreview{}, fmt.Errorf("failed to find fstore file info: %v, %v", evt.FileId, err)
+	}
+	if fileMissing(origin) {
+		rail.Warnf("fstore file %v is not found or deleted, %v", evt.FileId, evt.Identifier)
+		return failedAudioPreview(api.ThumbnailStatusFileNotFound, fstore.ErrFileNotFound), nil
+	}
+	if fstore.GuessMediaType(origin.Name) != fstore.MediaTypeAudio {
+		rail.Warnf("fstore file %v is not an audio file, %v", evt.FileId, origin.Name)
+		return failedAudioPreview(api.ThumbnailStatusUnsupportedFormat, fstore.ErrNotMediaFile), nil
 	}
 
-	return uploadFileId, nil
+	generated, err := GenAudioPreview(rail, origin, evt.Peaks)
+	if err != nil {
+		if errors.Is(err, ErrFileTooLarge) {
+			return GeneratedAudioPreview{}, err
+		}
+		if errors.Is(err, ErrInvalidMedia) {
+			rail.Warnf("Failed to generate audio preview, not a valid media file, giving up, fileId: %v, %v", evt.FileId, err)
+		} else {
+			rail.Errorf("Failed to generate audio preview, giving up, fileId: %v, %v", evt.FileId, err)
+		}
+		return failedAudioPreview(api.ThumbnailStatusProcessingFailed, err), nil
+	}
+	generated.Status = api.ThumbnailStatusOk
+	return generated, nil
 }
 
-func GenVideoThumbnail(rail miso.Rail, evt api.VidThumbnailTriggerEvent) (string, error) {
-	origin, err := fstore.FindFile(mysql.GetMySQL(), evt.FileId)
+// Thumbnails generated and uploaded to mini-fstore.
+type GeneratedThumbnails struct {
+	FileId     string            // file id of the first rendition
+	Renditions map[string]string // rendition name -> file id
+
+	PreviewFileId string // file id of the animated preview (video only)
+
+	Status string // api.ThumbnailStatus*
+	Error  string // reason of the failure
+}
+
+func GenImageThumbnail(rail miso.Rail, evt api.ImgThumbnailTriggerEvent) (GeneratedThumbnails, error) {
+	origin, err := store.FindFile(rail, evt.FileId)
+	if err != nil {
+		return GeneratedThumbnails{}, fmt.Errorf("failed to find fstore file info: %v, %v", evt.FileId, err)
+	}
+
+	if fileMissing(origin) {
+		rail.Warnf("fstore file %v is not found or deleted, %v", evt.FileId, evt.Identifier)
+		return failedThumbnails(api.ThumbnailStatusFileNotFound, fstore.ErrFileNotFound), nil
+	}
+
+	renditions := thumbnailRenditions(rail, evt.Presets, evt.Sizes, evt.Format, evt.Quality)
+	defer removeRenditions(renditions)
+
+	src := &lazyLocalFile{origin: origin}
+	defer src.Release()
+
+	// compress the origin image, if the compression failed, we just give up
+	return genCachedThumbnails(rail, origin, fstore.DerivImageThumbnail, "", renditions,
+		func(missing []Rendition) (GeneratedThumbnails, error) {
+			path, err := src.Path(rail)
+			if err != nil {
+				return GeneratedThumbnails{}, err
+			}
+			return resizeThumbnails(rail, path, origin.Name, evt.Identifier, missing)
+		})
+}
+
+func GenDocThumbnail(rail miso.Rail, evt api.DocThumbnailTriggerEvent) (GeneratedThumbnails, error) {
+	origin, err := store.FindFile(rail, evt.FileId)
+	if err != nil {
+		return GeneratedThumbnails{}, fmt.Errorf("failed to find fstore file info: %v, %v", evt.FileId, err)
+	}
+
+	if fileMissing(origin) {
+		rail.Warnf("fstore file %v is not found or deleted, %v", evt.FileId, evt.Identifier)
+		return failedThumbnails(api.ThumbnailStatusFileNotFound, fstore.ErrFileNotFound), nil
+	}
+
+	if !IsPreviewableDocument(origin.Name) {
+		rail.Warnf("fstore file %v is not a supported document, %v", evt.FileId, origin.Name)
+		return failedThumbnails(api.ThumbnailStatusUnsupportedFormat, ErrNotDocument), nil
+	}
+
+	renditions := thumbnailRenditions(rail, evt.Presets, evt.Sizes, evt.Format, evt.Quality)
+	defer removeRenditions(renditions)
+
+	src := &lazyLocalFile{origin: origin}
+	defer src.Release()
+
+	return genCachedThumbnails(rail, origin, fstore.DerivDocThumbnail, "", renditions,
+		func(missing []Rendition) (GeneratedThumbnails, error) {
+			path, err := src.Path(rail)
+			if err != nil {
+				return GeneratedThumbnails{}, err
+			}
+
+			dir, err := os.MkdirTemp(miso.GetPropStr(config.PropTempDir), "doc_")
+			if err != nil {
+				return GeneratedThumbnails{}, fmt.Errorf("failed to create temp dir, %v", err)
+			}
+			defer os.RemoveAll(dir)
+
+			// render the first page, then it's just like any other image
+			page, err := RenderDocumentFirstPage(rail, path, origin.Name, dir)
+			if err != nil {
+				rail.Errorf("Failed to render document, giving up, fileId: %v, %v", evt.FileId, err)
+				return failedThumbnails(api.ThumbnailStatusProcessingFailed, err), nil
+			}
+			return resizeThumbnails(rail, page, origin.Name, evt.Identifier, missing)
+		})
+}
+
+// Temp renditions of image thumbnails with the output format and quality resolved.
+func thumbnailRenditions(rail miso.Rail, presets []string, sizes []api.ThumbnailSize, format string, quality int) []Rendition {
+	renditions := tempRenditions(ResolveThumbnailSizes(rail, presets, sizes), "_compressed")
+	format, quality = ResolveThumbnailFormat(rail, format, quality)
+	for i := range renditions {
+		renditions[i].Format = format
+		renditions[i].Quality = quality
+	}
+	return renditions
+}
+
+func resizeThumbnails(rail miso.Rail, path string, originName string, identifier string, renditions []Rendition) (GeneratedThumbnails, error) {
+	if err := GiftResizeImage(rail, path, renditions); err != nil {
+		if errors.Is(err, ErrUnsupportedImageFormat) {
+			rail.Warnf("Image format not supported, identifier: %v, path: %v, %v", identifier, path, err)
+			return failedThumbnails(api.ThumbnailStatusUnsupportedFormat, err), nil
+		}
+		rail.Errorf("Failed to generate image thumbnail, giving up, identifier: %v, path: %v, %v", identifier, path, err)
+		return failedThumbnails(api.ThumbnailStatusProcessingFailed, err), nil
+	}
+
+	rail.Infof("Image %v compressed to %+v", identifier, renditions)
+
+	// upload the compressed images to mini-fstore
+	return uploadRenditions(rail, originName, renditions)
+}
+
+func GenVideoThumbnail(rail miso.Rail, evt api.VidThumbnailTriggerEvent) (GeneratedThumbnails, error) {
+	origin, err := store.FindFile(rail, evt.FileId)
 	if err != nil {
-		return "", fmt.Errorf("failed to find fstore file info: %v, %v", evt.FileId, err)
+		return GeneratedThumbnails{}, fmt.Errorf("failed to find fstore file info: %v, %v", evt.FileId, err)
 	}
 
-	if origin.Id < 1 || origin.IsDeleted() {
+	if fileMissing(origin) {
 		rail.Warnf("fstore file %v is not found or deleted, %v", evt.FileId, evt.Identifier)
-		return "", nil
+		return failedThumbnails(api.ThumbnailStatusFileNotFound, fstore.ErrFileNotFound), nil
 	}
 
-	// temp path for ffmpeg to extract first frame of the video
-	tmpPath := "/tmp/" + util.RandNum(20) + ".png"
-	defer os.Remove(tmpPath)
+	// temp paths for ffmpeg to extract frame of the video
+	renditions := tempRenditions(ResolveVideoThumbnailSizes(rail, evt.Presets, evt.Sizes), ".png")
+	defer removeRenditions(renditions)
 
-	stoPath := origin.StoragePath()
-	if err := ExtractFirstFrame(rail, stoPath, tmpPath); err != nil {
-		rail.Errorf("Failed to generate video thumbnail, giving up, fileId: %v, path: %v, %v", evt.FileId, stoPath, err)
-		return "", nil
+	src := &lazyLocalFile{origin: origin}
+	defer src.Release()
+	seek, candidates := ResolveFrameSeek(rail, evt.Seek, evt.Candidates)
+
+	// duration is needed for percentage based seek, candidate frames and preview
+	var durationMs int64
+	var probed bool
+	probeDuration := func() int64 {
+		if probed {
+			return durationMs
+		}
+		probed = true
+		stoPath, err := src.Path(rail)
+		if err != nil {
+			rail.Warnf("Failed to fetch video, fileId: %v, %v", evt.FileId, err)
+			return durationMs
+		}
+		md, err := ProbeVideo(rail, stoPath)
+		if errors.Is(err, ErrInvalidMedia) {
+			rail.Warnf("Failed to probe video duration, not a valid media file, fileId: %v, %v", evt.FileId, err)
+		} else if err != nil {
+			// e.g., ffprobe is not installed, the thumbnail is still extracted at the beginning of the video
+			rail.Errorf("Failed to probe video duration, fileId: %v, %v", evt.FileId, err)
+		} else {
+			durationMs = md.DurationMs
+		}
+		return durationMs
 	}
 
-	rail.Infof("Video %v first frame is extracted to %v", evt.Identifier, tmpPath)
+	extra := fmt.Sprintf("%v_c%d", seek.String(), candidates)
+	gen, err := genCachedThumbnails(rail, origin, fstore.DerivVideoThumbnail, extra, renditions,
+		func(missing []Rendition) (GeneratedThumbnails, error) {
+			stoPath, err := src.Path(rail)
+			if err != nil {
+				return GeneratedThumbnails{}, err
+			}
+			if seek.Percent > 0 || candidates > 1 {
+				probeDuration()
+			}
+			offset := seek.Offset(durationMs)
+			if candidates > 1 {
+				offset = PickBestFrame(rail, stoPath, offset, durationMs, candidates)
+			}
+
+			if err := ExtractFrameRenditions(rail, stoPath, offset, missing); err != nil {
+				rail.Errorf("Failed to generate video thumbnail, giving up, fileId: %v, path: %v, %v", evt.FileId, stoPath, err)
+				return failedThumbnails(api.ThumbnailStatusProcessingFailed, err), nil
+			}
 
-	// upload the extracted frame to mini-fstore
-	tmpName := origin.Name + "_thumbnail"
-	uploadFileId, err := fstore.UploadLocalFile(rail, tmpPath, tmpName)
+			rail.Infof("Video %v frame at %.3fs is extracted to %+v", evt.Identifier, offset, missing)
+
+			// upload the extracted frames to mini-fstore
+			return uploadRenditions(rail, origin.Name, missing)
+		})
+	if err != nil || gen.Status != api.ThumbnailStatusOk || !evt.Preview {
+		return gen, err
+	}
+
+	// the preview is optional, thumbnails are still replied even if it fails
+	found, err := store.FindDerivatives(rail, origin.Sha1, fstore.DerivVideoPreview, []string{""})
 	if err != nil {
-		return "", fmt.Errorf("failed to upload local fstore file, %v", err)
+		return GeneratedThumbnails{}, err
+	}
+	if derived, ok := found[""]; ok {
+		fileId, err := store.LinkFile(rail, derived, previewName(origin.Name))
+		if err != nil {
+			return GeneratedThumbnails{}, err
+		}
+		if fileId != "" {
+			gen.PreviewFileId = fileId
+			return gen, nil
+		}
 	}
 
-	return uploadFileId, nil
+	stoPath, err := src.Path(rail)
+	if err != nil {
+		return GeneratedThumbnails{}, err
+	}
+	preview := "/tmp/" + util.RandNum(20) + "_preview.gif"
+	defer os.Remove(preview)
+	if err := GenAnimatedPreview(rail, stoPath, probeDuration(), preview); err != nil {
+		rail.Errorf("Failed to generate video preview, fileId: %v, path: %v, %v", evt.FileId, stoPath, err)
+		return gen, nil
+	}
+	if gen.PreviewFileId, err = store.UploadLocalFile(rail, preview, previewName(origin.Name)); err != nil {
+		return GeneratedThumbnails{}, fmt.Errorf("failed to upload local fstore file, %v", err)
+	}
+	d := fstore.Derivative{SourceSha1: origin.Sha1, SourceFileId: origin.FileId, Operation: fstore.DerivVideoPreview,
+		FileId: gen.PreviewFileId}
+	if err := store.SaveDerivative(rail, d); err != nil {
+		rail.Warnf("Failed to save derivative, %v", err)
+	}
+	return gen, nil
+}
+
+func tempRenditions(resolved []api.ThumbnailSize, suffix string) []Rendition {
+	renditions := make([]Rendition, 0, len(resolved))
+	for _, s := range resolved {
+		renditions = append(renditions, Rendition{ThumbnailSize: s, Output: "/tmp/" + util.RandNum(20) + suffix})
+	}
+	return renditions
+}
+
+func removeRenditions(renditions []Rendition) {
+	for _, r := range renditions {
+		os.Remove(r.Output)
+	}
+}
+
+func uploadRenditions(rail miso.Rail, originName string, renditions []Rendition) (GeneratedThumbnails, error) {
+	gen := GeneratedThumbnails{Renditions: make(map[string]string, len(renditions)), Status: api.ThumbnailStatusOk}
+	for _, r := range renditions {
+		uploadFileId, err := store.UploadLocalFile(rail, r.Output, thumbnailName(originName, r.Name))
+		if err != nil {
+			return GeneratedThumbnails{}, fmt.Errorf("failed to upload local fstore file, %v", err)
+		}
+		if gen.FileId == "" {
+			gen.FileId = uploadFileId
+		}
+		gen.Renditions[r.Name] = uploadFileId
+	}
+	return gen, nil
 }
@@ -0,0 +1,112 @@
+package hammer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/fstore"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+)
+
+var (
+	ErrProbeUnavailable = errors.New("ffprobe is not available")
+	ErrInvalidMedia     = miso.NewErrf("File is not a valid media file").WithCode(api.InvalidMedia)
+)
+
+type ffprobeOutput struct {
+	Streams []ffprobeStream `json:"streams"`
+	Format  ffprobeFormat   `json:"format"`
+}
+
+type ffprobeStream struct {
+	CodecType    string            `json:"codec_type"`
+	CodecName    string            `json:"codec_name"`
+	Width        int               `json:"width"`
+	Height       int               `json:"height"`
+	Tags         map[string]string `json:"tags"`
+	SideDataList []struct {
+		Rotation float64 `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+type ffprobeFormat struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+	BitRate    string `json:"bit_rate"`
+}
+
+// Probe video metadata using ffprobe.
+//
+// ErrProbeUnavailable is returned if ffprobe is not installed, ErrInvalidMedia is returned if ffprobe rejects the file,
+// e.g., it's corrupted or not a media file at all. Other errors (e.g., timeout) mean that the probe itself failed.
+func ProbeVideo(rail miso.Rail, url string) (fstore.FileMetadata, error) {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return fstore.FileMetadata{}, fmt.Errorf("failed to probe url: %v, %w, %v", url, ErrProbeUnavailable, err)
+	}
+	args := []string{"-v", "error", "-print_format", "json", "-show_format", "-show_streams", url}
+	stdout, err := RunProcess(rail, Process{Name: "ffprobe", Args: args})
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && !errors.Is(err, ErrProcessTimeout) {
+			return fstore.FileMetadata{}, ErrInvalidMedia.WithInternalMsg("ffprobe rejected url: %v, %v", url, err)
+		}
+		return fstore.FileMetadata{}, fmt.Errorf("failed to probe url: %v, %w", url, err)
+	}
+	rail.Debugf("ffprobe finished, %v", string(stdout))
+	return parseFfprobeOutput(stdout)
+}
+
+func parseFfprobeOutput(out []byte) (fstore.FileMetadata, error) {
+	var md fstore.FileMetadata
+	var po ffprobeOutput
+	if err := json.Unmarshal(out, &po); err != nil {
+		return md, fmt.Errorf("failed to parse ffprobe output, %v", err)
+	}
+
+	md.Format = util.MaxLenStr(po.Format.FormatName, 64)
+	if d, err := strconv.ParseFloat(po.Format.Duration, 64); err == nil {
+		md.DurationMs = int64(math.Round(d * 1000))
+	}
+	if b, err := strconv.ParseInt(po.Format.BitRate, 10, 64); err == nil {
+		md.Bitrate = b
+	}
+
+	for _, s := range po.Streams {
+		switch s.CodecType {
+		case "video":
+			if md.VideoCodec != "" {
+				continue
+			}
+			md.VideoCodec = util.MaxLenStr(s.CodecName, 32)
+			md.Width, md.Height = s.Width, s.Height
+			if isRotatedSideways(s) {
+				md.Width, md.Height = md.Height, md.Width
+			}
+		case "audio":
+			if md.AudioCodec == "" {
+				md.AudioCodec = util.MaxLenStr(s.CodecName, 32)
+			}
+		}
+	}
+	return md, nil
+}
+
+// Whether the video stream is displayed rotated by 90 or 270 degree.
+func isRotatedSideways(s ffprobeStream) bool {
+	rotation := 0.0
+	if r, err := strconv.ParseFloat(s.Tags["rotate"], 64); err == nil {
+		rotation = r
+	}
+	for _, sd := range s.SideDataList {
+		if sd.Rotation != 0 {
+			rotation = sd.Rotation
+		}
+	}
+	return int(math.Abs(rotation))%180 == 90
+}
@@ -0,0 +1,25 @@
+package hammer
+
+import "testing"
+
+func TestParseFfprobeOutput(t *testing.T) {
+	out := `{
+		"streams": [
+			{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080,
+				"side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}]},
+			{"codec_type": "audio", "codec_name": "aac"}
+		],
+		"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "12.345678", "bit_rate": "4000000"}
+	}`
+	md, err := parseFfprobeOutput([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Width != 1080 || md.Height != 1920 {
+		t.Fatalf("dimensions should be swapped, %+v", md)
+	}
+	if md.DurationMs != 12346 || md.Bitrate != 4000000 || md.VideoCodec != "h264" || md.AudioCodec != "aac" {
+		t.Fatalf("incorrect metadata, %+v", md)
+	}
+	t.Logf("%+v", md)
+}
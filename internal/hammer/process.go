@@ -0,0 +1,105 @@
+package hammer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+)
+
+const (
+	maxStderrLen = 2000 // max length of stderr included in the error
+)
+
+var (
+	ErrProcessTimeout = errors.New("process timed out")
+
+	processSem     chan struct{}
+	processSemOnce sync.Once
+)
+
+func init() {
+	miso.SetDefProp(config.PropProcessTimeout, 120)
+	miso.SetDefProp(config.PropProcessTranscodeTimeout, 7200)
+	miso.SetDefProp(config.PropProcessMaxConcurrency, 2)
+}
+
+// External process (e.g., ffmpeg, ffprobe) to run.
+type Process struct {
+	Name    string        // name of the executable
+	Args    []string      // arguments
+	Timeout time.Duration // zero means the default timeout configured in 'fstore.process.timeout'
+	Stdout  io.Writer     // where stdout is written to, stdout is captured and returned if it's nil
+}
+
+func acquireProcessSlot(ctx context.Context) error {
+	processSemOnce.Do(func() {
+		processSem = make(chan struct{}, max(miso.GetPropInt(config.PropProcessMaxConcurrency), 1))
+	})
+	select {
+	case processSem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func releaseProcessSlot() {
+	<-processSem
+}
+
+// Default timeout of long running processes, e.g., transcoding.
+func transcodeTimeout() time.Duration {
+	return time.Duration(miso.GetPropInt(config.PropProcessTranscodeTimeout)) * time.Second
+}
+
+// Run the external process and wait for it to complete.
+//
+// The number of processes running concurrently is limited by 'fstore.process.max-concurrency' (regardless
+// of how many listeners are running), the time spent waiting for a slot is not included in the timeout.
+// The whole process group is killed on timeout. stderr is included in the returned error.
+func RunProcess(rail miso.Rail, p Process) ([]byte, error) {
+	ctx := rail.Context()
+	if err := acquireProcessSlot(ctx); err != nil {
+		return nil, fmt.Errorf("failed to wait for process slot, %w", err)
+	}
+	defer releaseProcessSlot()
+
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = time.Duration(miso.GetPropInt(config.PropProcessTimeout)) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, p.Name, p.Args...)
+	setProcessGroup(cmd)
+	cmd.WaitDelay = 5 * time.Second // don't wait forever for the pipes if the grandchildren are still holding them
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = p.Stdout
+	if cmd.Stdout == nil {
+		cmd.Stdout = &stdout
+	}
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = ErrProcessTimeout
+		}
+		return nil, fmt.Errorf("failed to run %v %v, took: %v, %w, stderr: %s", p.Name, p.Args, time.Since(start), err,
+			util.MaxLenStr(stderr.String(), maxStderrLen))
+	}
+	rail.Debugf("%v finished, took: %v", p.Name, time.Since(start))
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package hammer
+
+import "os/exec"
+
+// Process groups are not supported, only the process itself is killed when the context is done.
+func setProcessGroup(cmd *exec.Cmd) {
+}
@@ -0,0 +1,36 @@
+package hammer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func TestRunProcess(t *testing.T) {
+	rail := miso.EmptyRail()
+
+	out, err := RunProcess(rail, Process{Name: "sh", Args: []string{"-c", "echo ok"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(out)) != "ok" {
+		t.Fatalf("expected ok, got %q", out)
+	}
+
+	_, err = RunProcess(rail, Process{Name: "sh", Args: []string{"-c", "echo boom >&2; exit 1"}})
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected stderr in error, got %v", err)
+	}
+
+	start := time.Now()
+	_, err = RunProcess(rail, Process{Name: "sh", Args: []string{"-c", "sleep 10 & sleep 10"}, Timeout: 200 * time.Millisecond})
+	if !errors.Is(err, ErrProcessTimeout) {
+		t.Fatalf("expected ErrProcessTimeout, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("process group not killed on timeout, took: %v", time.Since(start))
+	}
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package hammer
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Run the process in its own process group, the whole group is killed when the context is done.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
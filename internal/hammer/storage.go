@@ -0,0 +1,307 @@
+package hammer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/mini-fstore/internal/fstore"
+	"github.com/curtisnewbie/miso/middleware/mysql"
+	"github.com/curtisnewbie/miso/miso"
+)
+
+var (
+	ErrFileTooLarge = errors.New("file is too large to be downloaded by hammer")
+
+	// Storage used by hammer listeners, replaced by remoteFileStore in InitPipeline if
+	// 'fstore.hammer.remote-access' is enabled.
+	store FileStore = localFileStore{}
+)
+
+func init() {
+	miso.SetDefProp(config.PropHammerRemoteAccess, false)
+	miso.SetDefProp(config.PropHammerRemoteService, "fstore")
+	miso.SetDefProp(config.PropHammerRemoteMaxSize, 2048)
+}
+
+// Access to files stored in mini-fstore that hammer needs to process them.
+type FileStore interface {
+	// Find file, zero value is returned if the file is not found.
+	FindFile(rail miso.Rail, fileId string) (fstore.File, error)
+
+	// Path of the file content on local disk, release must be called once the file is no longer needed.
+	//
+	// ErrFileTooLarge is returned if the file has to be downloaded but it exceeds 'fstore.hammer.max-size'.
+	LocalPath(rail miso.Rail, f fstore.File) (path string, release func(), err error)
+
+	// Upload the local file, returns the file id.
+	UploadLocalFile(rail miso.Rail, path string, name string) (string, error)
+
+	// Create a new file record sharing the content of fileId, returns the new file id, or empty string if fileId is
+	// deleted.
+	LinkFile(rail miso.Rail, fileId string, name string) (string, error)
+
+	FindDerivatives(rail miso.Rail, sourceSha1 string, operation string, params []string) (map[string]string, error)
+	SaveDerivative(rail miso.Rail, d fstore.Derivative) error
+	SaveFileMetadata(rail miso.Rail, md fstore.FileMetadata) error
+
+	// Register the media playlists and segments transcoded along with the HLS master playlist.
+	SaveHlsFiles(rail miso.Rail, playlistFileId string, files []api.HlsFile) error
+}
+
+// Check if the file is not found or deleted.
+func fileMissing(f fstore.File) bool {
+	return f.FileId == "" || f.IsDeleted()
+}
+
+// FileStore that accesses database and storage directory directly.
+type localFileStore struct{}
+
+func (localFileStore) FindFile(rail miso.Rail, fileId string) (fstore.File, error) {
+	return fstore.FindFile(mysql.GetMySQL(), fileId)
+}
+
+func (localFileStore) LocalPath(rail miso.Rail, f fstore.File) (string, func(), error) {
+	return f.StoragePath(), func() {}, nil
+}
+
+func (localFileStore) UploadLocalFile(rail miso.Rail, path string, name string) (string, error) {
+	return fstore.UploadLocalFile(rail, path, name)
+}
+
+func (localFileStore) LinkFile(rail miso.Rail, fileId string, name string) (string, error) {
+	return fstore.LinkFile(rail, mysql.GetMySQL(), fileId, name)
+}
+
+func (localFileStore) FindDerivatives(rail miso.Rail, sourceSha1 string, operation string, params []string) (map[string]string, error) {
+	return fstore.FindDerivatives(rail, mysql.GetMySQL(), sourceSha1, operation, params)
+}
+
+func (localFileStore) SaveDerivative(rail miso.Rail, d fstore.Derivative) error {
+	return fstore.SaveDerivative(rail, mysql.GetMySQL(), d)
+}
+
+func (localFileStore) SaveFileMetadata(rail miso.Rail, md fstore.FileMetadata) error {
+	return fstore.SaveFileMetadata(rail, mysql.GetMySQL(), md)
+}
+
+func (localFileStore) SaveHlsFiles(rail miso.Rail, playlistFileId string, files []api.HlsFile) error {
+	return fstore.SaveHlsFiles(rail, mysql.GetMySQL(), playlistFileId, files)
+}
+
+// FileStore that fetches and stores files through the HTTP API of mini-fstore, hammer can be deployed
+// separately without access to the database and the storage directory.
+type remoteFileStore struct {
+	service string // name of the mini-fstore service
+}
+
+func (s remoteFileStore) FindFile(rail miso.Rail, fileId string) (fstore.File, error) {
+	var r miso.GnResp[api.FstoreFile]
+	err := miso.NewDynTClient(rail, "/file/info", s.service).
+		AddQueryParams("fileId", fileId).
+		Get().
+		Json(&r)
+	if err != nil {
+		return fstore.File{}, fmt.Errorf("failed to fetch mini-fstore fileInfo, fileId: %v, %w", fileId, err)
+	}
+	ff, err := r.MappedRes(api.ErrMapper)
+	if err != nil {
+		if errors.Is(err, api.ErrFileNotFound) {
+			return fstore.File{}, nil
+		}
+		return fstore.File{}, err
+	}
+	return fstore.File{
+		FileId:     ff.FileId,
+		Name:       ff.Name,
+		Status:     ff.Status,
+		Size:       ff.Size,
+		Md5:        ff.Md5,
+		Sha1:       ff.Sha1,
+		UplTime:    ff.UplTime,
+		LogDelTime: ff.LogDelTime,
+		PhyDelTime: ff.PhyDelTime,
+	}, nil
+}
+
+func (s remoteFileStore) LocalPath(rail miso.Rail, f fstore.File) (string, func(), error) {
+	maxSize := remoteMaxSize()
+	if f.Size > maxSize {
+		return "", nil, fmt.Errorf("%w, fileId: %v, size: %v, max-size: %v", ErrFileTooLarge, f.FileId, f.Size, maxSize)
+	}
+
+	// only readable by current user, the temp dir may be shared
+	out, err := os.CreateTemp(miso.GetPropStr(config.PropTempDir), "hammer_*_"+filepath.Base(f.FileId))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp file, %v", err)
+	}
+	defer out.Close()
+	path := out.Name()
+	release := func() { os.Remove(path) }
+
+	_, err = miso.NewDynTClient(rail, "/file/direct", s.service).
+		AddQueryParams("fileId", f.FileId).
+		Get().
+		WriteTo(&limitedWriter{w: out, n: maxSize})
+	if err != nil {
+		release()
+		return "", nil, fmt.Errorf("failed to download mini-fstore file, fileId: %v, %w", f.FileId, err)
+	}
+	rail.Debugf("Downloaded %v to %v", f.FileId, path)
+	return path, release, nil
+}
+
+// Max size of the file downloaded through the HTTP API.
+func remoteMaxSize() int64 {
+	return int64(miso.GetPropInt(config.PropHammerRemoteMaxSize)) * 1024 * 1024
+}
+
+// Writer that fails with ErrFileTooLarge once more than n bytes are written.
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.n {
+		return 0, ErrFileTooLarge
+	}
+	l.n -= int64(len(p))
+	return l.w.Write(p)
+}
+
+func (s remoteFileStore) UploadLocalFile(rail miso.Rail, path string, name string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v, %w", path, err)
+	}
+	defer f.Close()
+
+	var res miso.GnResp[string]
+	err = miso.NewDynTClient(rail, "/file", s.service).
+		AddHeaders(map[string]string{"filename": name}).
+		Put(f).
+		Json(&res)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload mini-fstore file, filename: %v, %v", name, err)
+	}
+	uploadFileId, err := res.Res()
+	if err != nil {
+		return "", err
+	}
+
+	// exchange the real file id
+	var r miso.GnResp[api.FstoreFile]
+	err = miso.NewDynTClient(rail, "/file/info", s.service).
+		AddQueryParams("uploadFileId", uploadFileId).
+		Get().
+		Json(&r)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch mini-fstore fileInfo, uploadFileId: %v, %v", uploadFileId, err)
+	}
+	ff, err := r.MappedRes(api.ErrMapper)
+	if err != nil {
+		return "", err
+	}
+	return ff.FileId, nil
+}
+
+func (s remoteFileStore) LinkFile(rail miso.Rail, fileId string, name string) (string, error) {
+	var r miso.GnResp[string]
+	err := miso.NewDynTClient(rail, "/hammer/file/link", s.service).
+		PostJson(api.LinkFileReq{FileId: fileId, Name: name}).
+		Json(&r)
+	if err != nil {
+		return "", fmt.Errorf("failed to link mini-fstore file, fileId: %v, %v", fileId, err)
+	}
+	return r.Res()
+}
+
+func (s remoteFileStore) FindDerivatives(rail miso.Rail, sourceSha1 string, operation string, params []string) (map[string]string, error) {
+	if sourceSha1 == "" || len(params) < 1 {
+		return map[string]string{}, nil
+	}
+	var r miso.GnResp[map[string]string]
+	err := miso.NewDynTClient(rail, "/hammer/derivative/list", s.service).
+		PostJson(api.ListDerivativesReq{SourceSha1: sourceSha1, Operation: operation, Params: params}).
+		Json(&r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find mini-fstore file derivatives, sha1: %v, %v", sourceSha1, err)
+	}
+	return r.Res()
+}
+
+func (s remoteFileStore) SaveDerivative(rail miso.Rail, d fstore.Derivative) error {
+	if d.SourceSha1 == "" {
+		return nil // checksum not computed yet, can't be reused anyway
+	}
+	var r miso.GnResp[any]
+	req := api.SaveDerivativeReq{
+		SourceSha1:   d.SourceSha1,
+		SourceFileId: d.SourceFileId,
+		Operation:    d.Operation,
+		Params:       d.Params,
+		FileId:       d.FileId,
+	}
+	err := miso.NewDynTClient(rail, "/hammer/derivative", s.service).
+		PostJson(req).
+		Json(&r)
+	if err != nil {
+		return fmt.Errorf("failed to save mini-fstore file derivative, %+v, %v", d, err)
+	}
+	_, err = r.Res()
+	return err
+}
+
+func (s remoteFileStore) SaveFileMetadata(rail miso.Rail, md fstore.FileMetadata) error {
+	var r miso.GnResp[any]
+	err := miso.NewDynTClient(rail, "/hammer/metadata", s.service).
+		PostJson(api.SaveFileMetadataReq{FileId: md.FileId, Metadata: *md.ToApi()}).
+		Json(&r)
+	if err != nil {
+		return fmt.Errorf("failed to save mini-fstore file metadata, fileId: %v, %v", md.FileId, err)
+	}
+	_, err = r.MappedRes(api.ErrMapper)
+	return err
+}
+
+func (s remoteFileStore) SaveHlsFiles(rail miso.Rail, playlistFileId string, files []api.HlsFile) error {
+	var r miso.GnResp[any]
+	err := miso.NewDynTClient(rail, "/hammer/hls", s.service).
+		PostJson(api.SaveHlsFilesReq{PlaylistFileId: playlistFileId, Files: files}).
+		Json(&r)
+	if err != nil {
+		return fmt.Errorf("failed to save mini-fstore HLS files, playlist: %v, %v", playlistFileId, err)
+	}
+	_, err = r.MappedRes(api.ErrMapper)
+	return err
+}
+
+// Local copy of the file, it's only fetched when it's actually needed, e.g., not all derivatives are reused.
+type lazyLocalFile struct {
+	origin  fstore.File
+	path    string
+	release func()
+}
+
+func (l *lazyLocalFile) Path(rail miso.Rail) (string, error) {
+	if l.path != "" {
+		return l.path, nil
+	}
+	path, release, err := store.LocalPath(rail, l.origin)
+	if err != nil {
+		return "", err
+	}
+	l.path, l.release = path, release
+	return path, nil
+}
+
+func (l *lazyLocalFile) Release() {
+	if l.release != nil {
+		l.release()
+	}
+}
@@ -0,0 +1,42 @@
+package hammer
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/mini-fstore/internal/fstore"
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func TestLimitedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &limitedWriter{w: &buf, n: 5}
+	if _, err := w.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("def")); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("expected ErrFileTooLarge, got %v", err)
+	}
+	if buf.String() != "abc" {
+		t.Fatalf("expected abc, got %v", buf.String())
+	}
+}
+
+func TestRemoteLocalPathTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	miso.SetProp(config.PropTempDir, dir)
+	miso.SetProp(config.PropHammerRemoteMaxSize, 1)
+	defer miso.SetProp(config.PropHammerRemoteMaxSize, 2048)
+
+	f := fstore.File{FileId: "file_123", Size: 2 * 1024 * 1024}
+	_, _, err := remoteFileStore{service: "fstore"}.LocalPath(miso.EmptyRail(), f)
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("expected ErrFileTooLarge, got %v", err)
+	}
+	if l, _ := os.ReadDir(dir); len(l) > 0 {
+		t.Fatalf("nothing should be downloaded, %v", l)
+	}
+}
@@ -0,0 +1,158 @@
+package hammer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func init() {
+	miso.SetDefProp(config.PropThumbnailJpegQuality, 75)
+}
+
+var (
+	// Size of the builtin 'default' thumbnail preset, can be overriden in 'fstore.thumbnail.presets'.
+	DefThumbnailSize = api.ThumbnailSize{Name: api.DefThumbnailPreset, Width: 512, Height: 512}
+
+	// Legacy size of video thumbnails (scale=512:-2), used if nothing is requested and the 'default' preset is not overriden.
+	DefVideoThumbnailSize = api.ThumbnailSize{Name: api.DefThumbnailPreset, Width: 512}
+
+	ErrNoThumbnailSize = miso.NewErrf("None of the requested thumbnail presets or sizes is valid").WithCode(api.InvalidRequest)
+)
+
+// Thumbnail rendition to be generated.
+type Rendition struct {
+	api.ThumbnailSize
+	Output  string // path of the generated rendition
+	Format  string // output format, empty means the original format
+	Quality int    // jpeg quality, zero means the default quality
+}
+
+// Load thumbnail presets from property 'fstore.thumbnail.presets'.
+//
+// The 'default' preset is always available, malformed or invalid presets are logged and ignored.
+func LoadThumbnailPresets(rail miso.Rail) map[string]api.ThumbnailSize {
+	var presets []api.ThumbnailSize
+	if miso.HasProp(config.PropThumbnailPresets) {
+		miso.UnmarshalFromPropKey(config.PropThumbnailPresets, &presets)
+		if len(presets) < 1 {
+			rail.Errorf("Failed to load thumbnail presets, '%v' is malformed, only the builtin '%v' preset is available",
+				config.PropThumbnailPresets, api.DefThumbnailPreset)
+		}
+	}
+
+	m := make(map[string]api.ThumbnailSize, len(presets)+1)
+	m[DefThumbnailSize.Name] = DefThumbnailSize
+	for _, p := range presets {
+		if p.Name == "" || !isValidSize(p) {
+			rail.Errorf("Invalid thumbnail preset %+v in '%v', ignored", p, config.PropThumbnailPresets)
+			continue
+		}
+		m[p.Name] = p
+	}
+	return m
+}
+
+// Resolve the thumbnail sizes requested.
+//
+// Unknown presets or invalid sizes are ignored, the 'default' preset is used if nothing is requested.
+func ResolveThumbnailSizes(rail miso.Rail, presets []string, sizes []api.ThumbnailSize) []api.ThumbnailSize {
+	var conf map[string]api.ThumbnailSize
+	if len(presets) > 0 || len(sizes) == 0 {
+		conf = LoadThumbnailPresets(rail)
+	}
+
+	resolved := make([]api.ThumbnailSize, 0, len(presets)+len(sizes))
+	seen := map[string]struct{}{}
+	add := func(s api.ThumbnailSize) {
+		if _, ok := seen[s.Name]; ok {
+			return
+		}
+		seen[s.Name] = struct{}{}
+		resolved = append(resolved, s)
+	}
+
+	for _, name := range presets {
+		p, ok := conf[name]
+		if !ok {
+			rail.Warnf("Thumbnail preset '%v' not found, ignored", name)
+			continue
+		}
+		add(p)
+	}
+
+	for _, s := range sizes {
+		if !isValidSize(s) {
+			rail.Warnf("Invalid thumbnail size %+v, ignored", s)
+			continue
+		}
+		if s.Name == "" {
+			s.Name = fmt.Sprintf("%vx%v", s.Width, s.Height)
+		}
+		add(s)
+	}
+
+	if len(resolved) < 1 && len(presets) == 0 && len(sizes) == 0 {
+		resolved = append(resolved, conf[api.DefThumbnailPreset])
+	}
+	return resolved
+}
+
+// Resolve the video thumbnail sizes, same as ResolveThumbnailSizes except that DefVideoThumbnailSize is used if
+// nothing is requested and the 'default' preset is not overriden.
+func ResolveVideoThumbnailSizes(rail miso.Rail, presets []string, sizes []api.ThumbnailSize) []api.ThumbnailSize {
+	if len(presets) == 0 && len(sizes) == 0 && LoadThumbnailPresets(rail)[api.DefThumbnailPreset] == DefThumbnailSize {
+		return []api.ThumbnailSize{DefVideoThumbnailSize}
+	}
+	return ResolveThumbnailSizes(rail, presets, sizes)
+}
+
+// Resolve output format and jpeg quality of image thumbnails.
+//
+// quality is only used by jpeg, webp is always encoded losslessly, and avif is not supported.
+//
+// Properties 'fstore.thumbnail.format' and 'fstore.thumbnail.jpeg-quality' are used if not specified.
+func ResolveThumbnailFormat(rail miso.Rail, format string, quality int) (string, int) {
+	format = normalizeFormat(format)
+	if format == "" {
+		format = normalizeFormat(miso.GetPropStr(config.PropThumbnailFormat))
+	}
+	if format != "" && encodedFormat(format) != format {
+		rail.Warnf("Unsupported thumbnail format '%v', using the original format", format)
+		format = ""
+	}
+
+	if format == "webp" && quality > 0 {
+		rail.Infof("Thumbnail quality %v is ignored, webp thumbnails are always lossless", quality)
+	}
+	if quality < 1 || quality > 100 {
+		quality = miso.GetPropInt(config.PropThumbnailJpegQuality)
+	}
+	return format, quality
+}
+
+func normalizeFormat(format string) string {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "jpg" {
+		return "jpeg"
+	}
+	return format
+}
+
+func isValidSize(s api.ThumbnailSize) bool {
+	if s.Width < 0 || s.Height < 0 {
+		return false
+	}
+	return s.Width > 0 || s.Height > 0
+}
+
+// Name of the uploaded thumbnail file.
+func thumbnailName(originName string, rendition string) string {
+	if rendition == api.DefThumbnailPreset {
+		return originName + "_thumbnail"
+	}
+	return originName + "_thumbnail_" + rendition
+}
@@ -0,0 +1,48 @@
+package hammer
+
+import (
+	"testing"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/miso/miso"
+)
+
+func TestResolveThumbnailSizes(t *testing.T) {
+	rail := miso.EmptyRail()
+	miso.SetProp(config.PropThumbnailPresets, []map[string]any{
+		{"name": "small", "width": 128, "height": 128},
+		{"name": "wide", "width": 1024},
+	})
+
+	s := ResolveThumbnailSizes(rail, nil, nil)
+	if len(s) != 1 || s[0] != DefThumbnailSize {
+		t.Fatalf("should use default preset, %+v", s)
+	}
+
+	s = ResolveVideoThumbnailSizes(rail, nil, nil)
+	if len(s) != 1 || s[0] != DefVideoThumbnailSize || scaleFilter(s[0]) != "scale=512:-2" {
+		t.Fatalf("should use legacy video thumbnail size, %+v", s)
+	}
+
+	s = ResolveThumbnailSizes(rail, []string{"unknown"}, []api.ThumbnailSize{{Name: "bad"}})
+	if len(s) != 0 {
+		t.Fatalf("expected no sizes, %+v", s)
+	}
+
+	s = ResolveThumbnailSizes(rail, []string{"small", "wide", "unknown", "small"},
+		[]api.ThumbnailSize{{Width: 64, Height: 32}, {Name: "bad"}})
+	if len(s) != 3 {
+		t.Fatalf("expected 3 sizes, %+v", s)
+	}
+	if s[0].Name != "small" || s[0].Width != 128 {
+		t.Fatalf("incorrect preset, %+v", s[0])
+	}
+	if s[1].Name != "wide" || s[1].Width != 1024 || s[1].Height != 0 {
+		t.Fatalf("incorrect preset, %+v", s[1])
+	}
+	if s[2].Name != "64x32" {
+		t.Fatalf("incorrect custom size, %+v", s[2])
+	}
+	t.Logf("%+v", s)
+}
@@ -0,0 +1,168 @@
+package hammer
+
+import (
+	"fmt"
+	"image"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/disintegration/gift"
+)
+
+const (
+	FitContain = "contain" // image fit mode - scale down to fit within width x height, aspect ratio preserved
+	FitCover   = "cover"   // image fit mode - scale and crop to fill width x height, aspect ratio preserved
+	FitFill    = "fill"    // image fit mode - stretch to exactly width x height
+
+	maxTransformDimension = 4096
+)
+
+var (
+	ErrInvalidTransform = miso.NewErrf("Invalid image transform parameters").WithCode(api.InvalidRequest)
+	ErrNotImage         = miso.NewErrf("File is not an image").WithCode(api.NotImage)
+
+	imageFileExt = map[string]struct{}{
+		".jpg":  {},
+		".jpeg": {},
+		".png":  {},
+		".gif":  {},
+		".webp": {},
+		".bmp":  {},
+		".tif":  {},
+		".tiff": {},
+		".heic": {},
+		".heif": {},
+	}
+)
+
+// Transformation applied to image on the fly.
+type ImageTransform struct {
+	Width   int    // max width in pixels
+	Height  int    // max height in pixels
+	Fit     string // fit mode: contain, cover, fill
+	Format  string // output format, empty means the original format
+	Quality int    // jpeg quality (1-100), zero means the default quality
+}
+
+// Whether transformation is requested.
+func (t ImageTransform) IsZero() bool {
+	return t.Width == 0 && t.Height == 0 && t.Format == "" && t.Quality == 0
+}
+
+// Key that uniquely identifies the transformed variant of the file content.
+func (t ImageTransform) VariantKey(contentKey string) string {
+	return fmt.Sprintf("%s_%dx%d_%s_%s_q%d", contentKey, t.Width, t.Height, t.Fit, t.Format, t.Quality)
+}
+
+// Parse image transform parameters: w, h, fit, fmt, q.
+func ParseImageTransform(q url.Values) (ImageTransform, error) {
+	var t ImageTransform
+	var err error
+	if t.Width, err = parseTransformInt(q.Get("w"), maxTransformDimension); err != nil {
+		return t, ErrInvalidTransform.WithInternalMsg("invalid width, %v", err)
+	}
+	if t.Height, err = parseTransformInt(q.Get("h"), maxTransformDimension); err != nil {
+		return t, ErrInvalidTransform.WithInternalMsg("invalid height, %v", err)
+	}
+	if t.Quality, err = parseTransformInt(q.Get("q"), 100); err != nil {
+		return t, ErrInvalidTransform.WithInternalMsg("invalid quality, %v", err)
+	}
+
+	t.Fit = strings.ToLower(strings.TrimSpace(q.Get("fit")))
+	switch t.Fit {
+	case "":
+		t.Fit = FitContain
+	case FitContain, FitFill:
+	case FitCover:
+		if t.Width == 0 || t.Height == 0 {
+			return t, ErrInvalidTransform.WithInternalMsg("fit mode cover requires both width and height")
+		}
+	default:
+		return t, ErrInvalidTransform.WithInternalMsg("invalid fit mode: %v", t.Fit)
+	}
+
+	t.Format = strings.ToLower(strings.TrimSpace(q.Get("fmt")))
+	switch t.Format {
+	case "jpg":
+		t.Format = "jpeg"
+	case "", "jpeg", "png", "gif", "webp":
+	default:
+		return t, ErrInvalidTransform.WithInternalMsg("unsupported format: %v", t.Format)
+	}
+	return t, nil
+}
+
+func parseTransformInt(v string, max int) (int, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > max {
+		return 0, fmt.Errorf("%v out of range [0, %v]", n, max)
+	}
+	return n, nil
+}
+
+// Check whether the file is an image that can be transformed.
+func IsTransformableImage(name string) bool {
+	_, ok := imageFileExt[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// Transform image and write the result to output.
+//
+// Returns the format of the output image.
+func TransformImage(rail miso.Rail, file string, output string, t ImageTransform) (string, error) {
+	src, typ, err := loadImage(rail, file)
+	if err != nil {
+		return "", fmt.Errorf("failed to load image, filename: %v, %v", file, err)
+	}
+
+	var dst image.Image = src
+	if t.Width > 0 || t.Height > 0 {
+		imgFilter := gift.New(transformFilter(t))
+		nrgba := image.NewNRGBA(imgFilter.Bounds(src.Bounds()))
+		imgFilter.Draw(nrgba, src)
+		dst = nrgba
+	}
+
+	format := t.Format
+	if format == "" {
+		format = typ
+	}
+	format = encodedFormat(format)
+
+	f, err := os.Create(output)
+	if err != nil {
+		return "", fmt.Errorf("failed to create image file, filename: %v, %v", output, err)
+	}
+	defer f.Close()
+
+	if err := encodeImage(f, dst, format, t.Quality); err != nil {
+		return "", fmt.Errorf("image encode failed, filename: %v, %v", output, err)
+	}
+	return format, nil
+}
+
+func transformFilter(t ImageTransform) gift.Filter {
+	if t.Width == 0 || t.Height == 0 {
+		return gift.Resize(t.Width, t.Height, gift.LanczosResampling)
+	}
+	switch t.Fit {
+	case FitCover:
+		return gift.ResizeToFill(t.Width, t.Height, gift.LanczosResampling, gift.CenterAnchor)
+	case FitFill:
+		return gift.Resize(t.Width, t.Height, gift.LanczosResampling)
+	default:
+		return gift.ResizeToFit(t.Width, t.Height, gift.LanczosResampling)
+	}
+}
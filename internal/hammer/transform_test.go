@@ -0,0 +1,32 @@
+package hammer
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseImageTransform(t *testing.T) {
+	q, _ := url.ParseQuery("w=200&h=100&fit=Cover&fmt=jpg&q=80")
+	tr, err := ParseImageTransform(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Width != 200 || tr.Height != 100 || tr.Fit != FitCover || tr.Format != "jpeg" || tr.Quality != 80 {
+		t.Fatalf("incorrect transform, %+v", tr)
+	}
+
+	tr, err = ParseImageTransform(url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tr.IsZero() {
+		t.Fatalf("should be zero, %+v", tr)
+	}
+
+	for _, v := range []string{"w=-1", "w=99999", "q=101", "fit=cover&w=10", "fmt=bmp", "fit=abc", "h=abc"} {
+		q, _ := url.ParseQuery(v)
+		if _, err := ParseImageTransform(q); err == nil {
+			t.Fatalf("%v should be invalid", v)
+		}
+	}
+}
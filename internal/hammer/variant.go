@@ -0,0 +1,272 @@
+package hammer
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/curtisnewbie/mini-fstore/api"
+	"github.com/curtisnewbie/mini-fstore/internal/config"
+	"github.com/curtisnewbie/mini-fstore/internal/fstore"
+	"github.com/curtisnewbie/miso/miso"
+	"github.com/curtisnewbie/miso/util"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	variants = &variantCache{entries: map[string]*list.Element{}, ll: list.New()}
+
+	// concurrent requests of the same variant share one render
+	variantRenders singleflight.Group
+
+	// limits the number of images decoded concurrently, created lazily once the properties are loaded
+	variantSem     chan struct{}
+	variantSemOnce sync.Once
+)
+
+func init() {
+	miso.SetDefProp(config.PropImageVariantDir, "./variant")
+	miso.SetDefProp(config.PropImageVariantMaxSize, 1024)
+	miso.SetDefProp(config.PropImageVariantMaxConcurrency, 2)
+}
+
+type variantEntry struct {
+	key    string
+	path   string
+	format string
+	size   int64
+}
+
+// LRU cache of rendered image variants on local disk.
+type variantCache struct {
+	sync.Mutex
+	loaded  bool
+	size    int64
+	ll      *list.List // front is the most recently used
+	entries map[string]*list.Element
+}
+
+func (v *variantCache) maxSize() int64 {
+	return int64(miso.GetPropInt(config.PropImageVariantMaxSize)) * 1024 * 1024
+}
+
+// load existing variants on disk, must be called with lock held.
+func (v *variantCache) load(rail miso.Rail) error {
+	if v.loaded {
+		return nil
+	}
+	dir := variantDir()
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to MkdirAll, %v", err)
+	}
+	ent, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read dir, %v", err)
+	}
+
+	infos := make([]os.FileInfo, 0, len(ent))
+	for _, e := range ent {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, fi)
+	}
+
+	// least recently used first
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().Before(infos[j].ModTime()) })
+	for _, fi := range infos {
+		ext := filepath.Ext(fi.Name())
+		en := &variantEntry{
+			key:    strings.TrimSuffix(fi.Name(), ext),
+			path:   dir + fi.Name(),
+			format: strings.TrimPrefix(ext, "."),
+			size:   fi.Size(),
+		}
+		v.entries[en.key] = v.ll.PushFront(en)
+		v.size += en.size
+	}
+	v.loaded = true
+	rail.Infof("Loaded %v image variants, total size: %v", len(infos), v.size)
+	return nil
+}
+
+func (v *variantCache) get(rail miso.Rail, key string) (variantEntry, bool, error) {
+	v.Lock()
+	defer v.Unlock()
+	if err := v.load(rail); err != nil {
+		return variantEntry{}, false, err
+	}
+
+	el, ok := v.entries[key]
+	if !ok {
+		return variantEntry{}, false, nil
+	}
+	v.ll.MoveToFront(el)
+	en := el.Value.(*variantEntry)
+
+	// mod time is used to restore the LRU order after restart
+	now := time.Now()
+	_ = os.Chtimes(en.path, now, now)
+	return *en, true, nil
+}
+
+func (v *variantCache) put(rail miso.Rail, en variantEntry) {
+	v.Lock()
+	defer v.Unlock()
+
+	if el, ok := v.entries[en.key]; ok {
+		v.size -= el.Value.(*variantEntry).size
+		v.ll.Remove(el)
+	}
+	v.entries[en.key] = v.ll.PushFront(&en)
+	v.size += en.size
+
+	max := v.maxSize()
+	for v.size > max && v.ll.Len() > 1 {
+		el := v.ll.Back()
+		evicted := el.Value.(*variantEntry)
+		v.ll.Remove(el)
+		delete(v.entries, evicted.key)
+		v.size -= evicted.size
+		if err := os.Remove(evicted.path); err != nil && !os.IsNotExist(err) {
+			rail.Warnf("Failed to remove evicted image variant %v, %v", evicted.path, err)
+		}
+		rail.Debugf("Evicted image variant %v", evicted.path)
+	}
+}
+
+func variantDir() string {
+	dir := miso.GetPropStr(config.PropImageVariantDir)
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	return dir
+}
+
+// Find or render the variant of the file.
+func resolveImageVariant(rail miso.Rail, ff fstore.DFile, t ImageTransform) (variantEntry, error) {
+	if !IsTransformableImage(ff.Name) {
+		return variantEntry{}, ErrNotImage
+	}
+
+	contentKey := ff.Sha1
+	if contentKey == "" {
+		contentKey = filepath.Base(ff.StoragePath())
+	}
+	key := t.VariantKey(contentKey)
+
+	if en, ok, err := variants.get(rail, key); err != nil {
+		return en, err
+	} else if ok {
+		return en, nil
+	}
+
+	v, err, shared := variantRenders.Do(key, func() (any, error) {
+		return renderImageVariant(rail, ff, t, key)
+	})
+	if err != nil {
+		return variantEntry{}, err
+	}
+	if shared {
+		rail.Debugf("Image variant %v rendered by concurrent request", key)
+	}
+	return v.(variantEntry), nil
+}
+
+// Render the image variant and put it in the cache, at most 'fstore.image-variant.max-concurrency' variants are
+// rendered at the same time.
+func renderImageVariant(rail miso.Rail, ff fstore.DFile, t ImageTransform, key string) (variantEntry, error) {
+	variantSemOnce.Do(func() {
+		variantSem = make(chan struct{}, max(miso.GetPropInt(config.PropImageVariantMaxConcurrency), 1))
+	})
+	variantSem <- struct{}{} // not cancelled with the request, the render may be shared by the others
+	defer func() { <-variantSem }()
+
+	// rendered by a previous request while waiting
+	if en, ok, err := variants.get(rail, key); err != nil {
+		return en, err
+	} else if ok {
+		return en, nil
+	}
+
+	// render to a temp file first, the variant only becomes visible once it's fully written
+	tmp := variantDir() + "." + util.RandNum(20)
+	defer os.Remove(tmp)
+
+	start := time.Now()
+	format, err := TransformImage(rail, ff.StoragePath(), tmp, t)
+	if err != nil {
+		return variantEntry{}, err
+	}
+	fi, err := os.Stat(tmp)
+	if err != nil {
+		return variantEntry{}, fmt.Errorf("failed to stat rendered image variant, %v", err)
+	}
+
+	en := variantEntry{key: key, path: variantDir() + key + "." + format, format: format, size: fi.Size()}
+	if err := os.Rename(tmp, en.path); err != nil {
+		return variantEntry{}, fmt.Errorf("failed to move rendered image variant from %v to %v, %v", tmp, en.path, err)
+	}
+	variants.put(rail, en)
+	rail.Infof("Rendered image variant %v for %v, took: %v", en.path, ff.FileId, time.Since(start))
+	return en, nil
+}
+
+// Serve the transformed image variant of the file.
+//
+// If attachment is true, Content-Disposition header is set using filename.
+func ServeImageVariant(rail miso.Rail, w http.ResponseWriter, r *http.Request, fileKey string, t ImageTransform, attachment bool) error {
+	op := api.FileOpStream
+	if attachment {
+		op = api.FileOpDownload
+	}
+	cachedFile, ff, err := fstore.ResolveFileAccess(rail, fileKey, fstore.NewFileAccess(r, op))
+	if err != nil {
+		return err
+	}
+
+	en, err := resolveImageVariant(rail, ff, t)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(en.path)
+	if err != nil {
+		return fmt.Errorf("failed to open image variant, %v", err)
+	}
+	defer f.Close()
+
+	headers := w.Header()
+	headers.Set("Content-Type", "image/"+en.format)
+	if attachment {
+		dname := cachedFile.Name
+		if dname == "" {
+			dname = ff.Name
+		}
+		headers.Set("Content-Disposition", "attachment; filename="+url.QueryEscape(variantFilename(dname, en.format)))
+	}
+	http.ServeContent(w, r, "", time.Time{}, f)
+	return nil
+}
+
+func variantFilename(name string, format string) string {
+	ext := "." + format
+	if format == "jpeg" {
+		ext = ".jpg"
+	}
+	if strings.EqualFold(filepath.Ext(name), ext) {
+		return name
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ext
+}
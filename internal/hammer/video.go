@@ -2,17 +2,69 @@ package hammer
 
 import (
 	"fmt"
-	"os/exec"
+	"strconv"
+	"strings"
 
+	"github.com/curtisnewbie/mini-fstore/api"
 	"github.com/curtisnewbie/miso/miso"
 )
 
+// Extract first frame of the video as a thumbnail of DefVideoThumbnailSize, the aspect ratio is preserved.
 func ExtractFirstFrame(rail miso.Rail, url string, output string) error {
-	cmd := exec.Command("ffmpeg", "-i", url, "-t", "1", "-frames:v", "1", "-vf", "scale=512:-2", output)
-	stdout, err := cmd.Output()
+	return ExtractFirstFrameRenditions(rail, url, []Rendition{{ThumbnailSize: DefVideoThumbnailSize, Output: output}})
+}
+
+// Extract first frame of the video and scale it to all the renditions using a single ffmpeg call.
+func ExtractFirstFrameRenditions(rail miso.Rail, url string, renditions []Rendition) error {
+	return ExtractFrameRenditions(rail, url, 0, renditions)
+}
+
+// Extract the frame at the given offset (in seconds) of the video and scale it to all the renditions using a single ffmpeg call.
+func ExtractFrameRenditions(rail miso.Rail, url string, offset float64, renditions []Rendition) error {
+	if len(renditions) < 1 {
+		return nil
+	}
+	stdout, err := RunProcess(rail, Process{Name: "ffmpeg", Args: frameArgs(url, offset, renditions)})
 	if err != nil {
-		return fmt.Errorf("failed to call ffmpeg for url: %v, target output: %v, %w", url, output, err)
+		return fmt.Errorf("failed to extract frame, url: %v, offset: %v, renditions: %+v, %w", url, offset, renditions, err)
 	}
 	rail.Infof("ffmpeg finished, %v", string(stdout))
 	return nil
 }
+
+func frameArgs(url string, offset float64, renditions []Rendition) []string {
+	var args []string
+	if offset > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(offset, 'f', 3, 64)) // seek input, much faster than seeking output
+	}
+	args = append(args, "-i", url, "-t", "1")
+	if len(renditions) == 1 {
+		r := renditions[0]
+		return append(args, "-frames:v", "1", "-vf", scaleFilter(r.ThumbnailSize), r.Output)
+	}
+
+	// split the decoded frame, scale each of them, and map them to different outputs
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("[0:v]split=%d", len(renditions)))
+	for i := range renditions {
+		b.WriteString(fmt.Sprintf("[s%d]", i))
+	}
+	for i, r := range renditions {
+		b.WriteString(fmt.Sprintf(";[s%d]%v[o%d]", i, scaleFilter(r.ThumbnailSize), i))
+	}
+	args = append(args, "-filter_complex", b.String())
+	for i, r := range renditions {
+		args = append(args, "-map", fmt.Sprintf("[o%d]", i), "-frames:v", "1", r.Output)
+	}
+	return args
+}
+
+func scaleFilter(s api.ThumbnailSize) string {
+	if s.Width > 0 && s.Height > 0 {
+		return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", s.Width, s.Height)
+	}
+	if s.Width > 0 {
+		return fmt.Sprintf("scale=%d:-2", s.Width)
+	}
+	return fmt.Sprintf("scale=-2:%d", s.Height)
+}
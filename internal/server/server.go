@@ -1,8 +1,10 @@
 package server
 
 import (
+	"fmt"
 	"os"
 
+	"github.com/curtisnewbie/mini-fstore/internal/config"
 	"github.com/curtisnewbie/mini-fstore/internal/fstore"
 	"github.com/curtisnewbie/mini-fstore/internal/hammer"
 	"github.com/curtisnewbie/mini-fstore/internal/web"
@@ -11,20 +13,76 @@ import (
 	"github.com/curtisnewbie/miso/miso"
 )
 
+const (
+	ModeAll    = "all"    // run both the HTTP API and hammer
+	ModeApi    = "api"    // run the HTTP API only
+	ModeHammer = "hammer" // run hammer only, i.e., media processing workers
+)
+
 func init() {
+	miso.SetDefProp(config.PropMode, ModeAll)
 	miso.PreServerBootstrap(func(rail miso.Rail) error {
-		rail.Infof("mini-fstore version: %v", Version)
-		return nil
+		rail.Infof("mini-fstore version: %v, mode: %v", Version, miso.GetPropStr(config.PropMode))
+		switch miso.GetPropStr(config.PropMode) {
+		case ModeAll, ModeApi, ModeHammer:
+			return nil
+		default:
+			return fmt.Errorf("invalid 'fstore.mode': '%v', expected one of: %v, %v, %v",
+				miso.GetPropStr(config.PropMode), ModeAll, ModeApi, ModeHammer)
+		}
 	})
 }
 
 func BootstrapServer(args []string) {
 	common.LoadBuiltinPropagationKeys()
 	logbot.EnableLogbotErrLogReport()
-	miso.PreServerBootstrap(web.RegisterRoutes)
-	miso.PreServerBootstrap(fstore.InitPipeline)
-	miso.PreServerBootstrap(fstore.InitTrashDir)
-	miso.PreServerBootstrap(fstore.InitStorageDir)
-	miso.PreServerBootstrap(hammer.InitPipeline)
+	miso.PreServerBootstrap(apiOnly(web.RegisterRoutes))
+	miso.PreServerBootstrap(apiOnly(fstore.InitPipeline))
+	miso.PreServerBootstrap(storageOnly(fstore.InitTrashDir))
+	miso.PreServerBootstrap(storageOnly(fstore.InitStorageDir))
+	miso.PreServerBootstrap(apiOnly(fstore.ScheduleRemoveDeletedFilesTask))
+	miso.PreServerBootstrap(apiOnly(fstore.SchedulePurgeTrashTask))
+	miso.PreServerBootstrap(hammerOnly(hammer.InitPipeline))
 	miso.BootstrapServer(os.Args)
 }
+
+func apiEnabled() bool {
+	mode := miso.GetPropStr(config.PropMode)
+	return mode == ModeAll || mode == ModeApi
+}
+
+func hammerEnabled() bool {
+	mode := miso.GetPropStr(config.PropMode)
+	return mode == ModeAll || mode == ModeHammer
+}
+
+// Run the callback only if the HTTP API is enabled in current mode.
+func apiOnly(f func(rail miso.Rail) error) func(rail miso.Rail) error {
+	return func(rail miso.Rail) error {
+		if !apiEnabled() {
+			return nil
+		}
+		return f(rail)
+	}
+}
+
+// Run the callback only if hammer is enabled in current mode.
+func hammerOnly(f func(rail miso.Rail) error) func(rail miso.Rail) error {
+	return func(rail miso.Rail) error {
+		if !hammerEnabled() {
+			return nil
+		}
+		return f(rail)
+	}
+}
+
+// Run the callback only if storage directory is accessed directly in current mode, hammer workers that
+// access files through the HTTP API don't need it.
+func storageOnly(f func(rail miso.Rail) error) func(rail miso.Rail) error {
+	return func(rail miso.Rail) error {
+		if !apiEnabled() && miso.GetPropBool(config.PropHammerRemoteAccess) {
+			return nil
+		}
+		return f(rail)
+	}
+}
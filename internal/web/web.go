@@ -1,6 +1,7 @@
 package web
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"github.com/curtisnewbie/mini-fstore/api"
 	"github.com/curtisnewbie/mini-fstore/internal/config"
 	"github.com/curtisnewbie/mini-fstore/internal/fstore"
+	"github.com/curtisnewbie/mini-fstore/internal/hammer"
 	"github.com/curtisnewbie/miso/middleware/mysql"
 	"github.com/curtisnewbie/miso/middleware/redis"
 	"github.com/curtisnewbie/miso/middleware/user-vault/auth"
@@ -25,6 +27,8 @@ var (
 
 const (
 	authorization = "Authorization"
+
+	uploadFileIdKeyPrefix = "mini-fstore:upload:fileId:" // uploadFileId -> actual file_id
 )
 
 func RegisterRoutes(rail miso.Rail) error {
@@ -36,7 +40,12 @@ func RegisterRoutes(rail miso.Rail) error {
 			is generated and used.
 		`).
 		Public().
-		DocQueryParam("key", "temporary file key")
+		DocQueryParam("key", "temporary file key or signed file key").
+		DocQueryParam("w", "(image only) max width of the transformed image").
+		DocQueryParam("h", "(image only) max height of the transformed image").
+		DocQueryParam("fit", "(image only) fit mode of the transformed image: contain (default), cover, fill").
+		DocQueryParam("fmt", "(image only) format of the transformed image: jpeg, png, gif, webp (lossless)").
+		DocQueryParam("q", "(image only) jpeg quality of the transformed image (1-100)")
 
 	miso.RawGet("/file/raw", TempKeyDownloadFileEp).
 		Desc(`
@@ -44,7 +53,23 @@ func RegisterRoutes(rail miso.Rail) error {
 			authorization, since a temporary file_key is generated and used.
 		`).
 		Public().
-		DocQueryParam("key", "temporary file key")
+		DocQueryParam("key", "temporary file key or signed file key").
+		DocQueryParam("w", "(image only) max width of the transformed image").
+		DocQueryParam("h", "(image only) max height of the transformed image").
+		DocQueryParam("fit", "(image only) fit mode of the transformed image: contain (default), cover, fill").
+		DocQueryParam("fmt", "(image only) format of the transformed image: jpeg, png, gif, webp (lossless)").
+		DocQueryParam("q", "(image only) jpeg quality of the transformed image (1-100)")
+
+	miso.RawGet("/file/hls", TempKeyHlsEp).
+		Desc(`
+			HLS streaming using temporary file key of the master playlist transcoded by TranscodeHlsPipeline,
+			the file_key's ttl is extended with each subsequent request. Media playlists and segments are
+			served by the same endpoint, URIs in the playlists are rewritten to include the file_key. Only the
+			files registered against the master playlist during the transcoding are served.
+		`).
+		Public().
+		DocQueryParam("key", "temporary file key or signed file key of the master playlist").
+		DocQueryParam("f", "file_id of the media playlist or segment, the master playlist is served if absent")
 
 	miso.Put("/file", UploadFileEp).
 		Desc("Upload file. A temporary file_id is returned, which should be used to exchange the real file_id").
@@ -54,10 +79,31 @@ func RegisterRoutes(rail miso.Rail) error {
 	miso.IGet("/file/info", GetFileInfoEp).
 		Desc("Fetch file info")
 
+	miso.IPost("/file/info/batch", GetFileInfosEp).
+		Desc(`
+			Fetch info of files in batch by file_ids and/or uploadFileIds (at most 1000 in total). One result is
+			returned for each requested id in order (file_ids first), files that are not found are marked as not found.
+		`)
+
 	miso.IGet("/file/key", GenFileKeyEp).
 		Desc(`
 			Generate temporary file key for downloading and streaming. This endpoint is expected to be called
-			internally by another backend service that validates the ownership of the file properly.
+			internally by another backend service that validates the ownership of the file properly. The key
+			can be restricted to download-only or stream-only, limited number of downloads, absolute expiry,
+			the client ip or the referer.
+		`)
+
+	miso.IPost("/file/key/batch", GenFileKeysEp).
+		Desc(`
+			Generate temporary file keys in batch, returns file_id -> file key. All the files must exist, otherwise
+			no key is generated. This endpoint is expected to be called internally by another backend service that
+			validates the ownership of the files properly.
+		`)
+
+	miso.IPost("/file/key/revoke", RevokeFileKeyEp).
+		Desc(`
+			Revoke temporary file key, or all the temporary file keys of the file. Signed file keys are not
+			affected. File keys are revoked automatically when the file is deleted.
 		`)
 
 	miso.RawGet("/file/direct", DirectDownloadFileEp).
@@ -71,9 +117,74 @@ func RegisterRoutes(rail miso.Rail) error {
 	miso.IDelete("/file", DeleteFileEp).
 		Desc("Mark file as deleted.")
 
+	miso.IPost("/file/delete/batch", DeleteFilesEp).
+		Desc(`
+			Mark files as deleted in batch (at most 1000 files). One result is returned for each file in order,
+			a failure of one file doesn't affect the others.
+		`)
+
+	miso.IPost("/file/undelete", UndeleteFilesEp).
+		Desc(`
+			Restore logically deleted files back to normal in batch (at most 1000 files). Files can only be restored
+			before they are removed physically, FILE_REMOVED is returned otherwise. One result is returned for
+			each file in order.
+		`)
+
+	miso.IPost("/file/restore", RestoreFilesEp).
+		Desc(`
+			Restore deleted files in batch (at most 1000 files), including files that have been removed physically
+			using the 'trash' strategy, the blobs are moved back from the trash dir. FILE_REMOVED is returned if the
+			blob is no longer in the trash dir. One result is returned for each file in order.
+		`)
+
 	miso.IPost("/file/unzip", UnzipFileEp).
 		Desc("Unzip archive, upload all the zip entries, and reply the final results back to the caller asynchronously")
 
+	miso.IPost("/file/strip-metadata", StripFileMetadataEp).
+		Desc(`
+			Strip EXIF (including GPS), XMP and IPTC metadata from jpeg file. EXIF orientation is preserved.
+			The original file is untouched, a new file is created and it's file_id is returned.
+		`)
+
+	miso.IPost("/file/metadata/extract", ExtractFileMetadataEp).
+		Desc(`
+			Trigger media metadata extraction of image, video or audio file asynchronously. Video and audio
+			metadata is probed using ffprobe. The extracted metadata is returned by /file/info once it's ready.
+		`)
+
+	// endpoints for hammer workers deployed separately, see 'fstore.hammer.remote-access'
+	miso.IPost("/hammer/derivative/list", ListDerivativesEp).
+		Desc(`
+			Find files derived from the same content using the same operation and parameters. This endpoint is
+			expected to be protected and only used internally by hammer workers.
+		`)
+
+	miso.IPost("/hammer/derivative", SaveDerivativeEp).
+		Desc(`
+			Record derived file generated from the content of the source file. This endpoint is expected to be
+			protected and only used internally by hammer workers.
+		`)
+
+	miso.IPost("/hammer/file/link", LinkFileEp).
+		Desc(`
+			Create a new file record that shares the content of the file, the file_id of the new record is returned,
+			or empty string if the file is deleted. This endpoint is expected to be protected and only used
+			internally by hammer workers.
+		`)
+
+	miso.IPost("/hammer/hls", SaveHlsFilesEp).
+		Desc(`
+			Register the media playlists and segments transcoded along with the HLS master playlist, only the
+			registered files are served by /file/hls. This endpoint is expected to be protected and only used
+			internally by hammer workers.
+		`)
+
+	miso.IPost("/hammer/metadata", SaveFileMetadataEp).
+		Desc(`
+			Save media metadata extracted from the file. This endpoint is expected to be protected and only used
+			internally by hammer workers.
+		`)
+
 	// endpoints for file backup
 	if miso.GetPropBool(config.PropEnableFstoreBackup) && miso.GetPropStr(config.PropBackupAuthSecret) != "" {
 		rail.Infof("Enabled file backup endpoints")
@@ -92,15 +203,46 @@ func RegisterRoutes(rail miso.Rail) error {
 
 	// curl -X POST http://localhost:8084/maintenance/remove-deleted
 	miso.Post("/maintenance/remove-deleted", RemoveDeletedFilesEp).
-		Desc("Remove files that are logically deleted and not linked (symbolically)")
+		Desc("Submit maintenance job that removes files that are logically deleted and not linked (symbolically), returns the job id")
 
 	// curl -X POST http://localhost:8084/maintenance/sanitize-storage
 	miso.Post("/maintenance/sanitize-storage", SanitizeStorageEp).
-		Desc("Sanitize storage, remove files in storage directory that don't exist in database")
+		Desc("Submit maintenance job that sanitizes storage, files in storage directory that don't exist in database are removed, returns the job id")
+
+	// curl -X POST http://localhost:8084/maintenance/repair-blobs
+	miso.Post("/maintenance/repair-blobs", RepairBlobsEp).
+		Desc(`
+			Submit maintenance job that repairs blobs shared by symbolic files (deduplicated) that are left in an
+			inconsistent state, e.g., blobs that are no longer used by any file are removed, blobs removed while they
+			are still linked are brought back from trash. Returns the job id.
+		`)
+
+	// curl -X POST http://localhost:8084/maintenance/purge-trash
+	miso.Post("/maintenance/purge-trash", PurgeTrashEp).
+		Desc("Submit maintenance job that permanently removes files in trash directory that are trashed before the retention period, returns the job id")
 
 	// curl -X POST http://localhost:8084/maintenance/compute-checksum
 	miso.Post("/maintenance/compute-checksum", ComputeChecksumEp).
-		Desc("Compute files' checksum if absent")
+		Desc("Submit maintenance job that computes files' checksum if absent, returns the job id")
+
+	// curl -X POST http://localhost:8084/maintenance/job -d '{"name":"sanitize-storage"}'
+	miso.IPost("/maintenance/job", SubmitMaintenanceJobEp).
+		Desc(`
+			Submit maintenance job, the job is run asynchronously and the job id is returned. Only one job of the same
+			name runs at a time across all nodes.
+		`)
+
+	// curl http://localhost:8084/maintenance/job?jobId=job_123
+	miso.IGet("/maintenance/job", GetMaintenanceJobEp).
+		Desc("Fetch status and progress of maintenance job")
+
+	// curl -X POST http://localhost:8084/maintenance/job/cancel -d '{"jobId":"job_123"}'
+	miso.IPost("/maintenance/job/cancel", CancelMaintenanceJobEp).
+		Desc("Cancel running maintenance job, the job is stopped shortly after")
+
+	// curl http://localhost:8084/maintenance/job/history?name=remove-deleted
+	miso.IGet("/maintenance/job/history", ListMaintenanceJobsEp).
+		Desc("List the latest maintenance jobs")
 
 	auth.ExposeResourceInfo([]auth.Resource{
 		{Name: "Fstore File Upload", Code: ResCodeFstoreUpload},
@@ -155,9 +297,29 @@ func DeleteFileEp(inb *miso.Inbound, req DeleteFileReq) (any, error) {
 	return nil, fstore.LDelFile(rail, mysql.GetMySQL(), fileId)
 }
 
+// mark files deleted in batch
+func DeleteFilesEp(inb *miso.Inbound, req api.FileIdsReq) ([]api.FileOpResult, error) {
+	return fstore.LDelFiles(inb.Rail(), mysql.GetMySQL(), req.FileIds)
+}
+
+// restore logically deleted files in batch
+func UndeleteFilesEp(inb *miso.Inbound, req api.FileIdsReq) ([]api.FileOpResult, error) {
+	return fstore.UndeleteFiles(inb.Rail(), mysql.GetMySQL(), req.FileIds)
+}
+
+// restore deleted files in batch, including the ones in trash
+func RestoreFilesEp(inb *miso.Inbound, req api.FileIdsReq) ([]api.FileOpResult, error) {
+	return fstore.RestoreFiles(inb.Rail(), mysql.GetMySQL(), req.FileIds)
+}
+
 type DownloadFileReq struct {
 	FileId   string `form:"fileId" desc:"actual file_id of the file record"`
 	Filename string `form:"filename" desc:"the name that will be used when downloading the file"`
+	Scope    string `form:"scope" desc:"restrict the key to 'download' or 'stream', both are allowed if empty"`
+	MaxUses  int    `form:"maxUses" desc:"max number of downloads, scope must be download"`
+	ExpireAt int64  `form:"expireAt" desc:"absolute expiry (unix timestamp in seconds), the key never outlives it even if it's refreshed"`
+	ClientIp string `form:"clientIp" desc:"bind the key to the client ip"`
+	Referer  string `form:"referer" desc:"bind the key to the referer (prefix)"`
 }
 
 // generate random file key for downloading the file
@@ -178,11 +340,68 @@ func GenFileKeyEp(inb *miso.Inbound, req DownloadFileReq) (string, error) {
 	}
 	filename = strings.TrimSpace(filename)
 
-	k, re := fstore.RandFileKey(rail, filename, fileId)
-	rail.Infof("Generated random key %s for fileId %s (using filename: '%s')", k, fileId, filename)
+	opts := fstore.FileKeyOptions{
+		Scope:    strings.TrimSpace(req.Scope),
+		MaxUses:  req.MaxUses,
+		ExpireAt: req.ExpireAt,
+		ClientIp: strings.TrimSpace(req.ClientIp),
+		Referer:  strings.TrimSpace(req.Referer),
+	}
+	k, re := fstore.RandScopedFileKey(rail, filename, fileId, opts)
+	rail.Infof("Generated random key %s for fileId %s (using filename: '%s', options: %+v)", k, fileId, filename, opts)
 	return k, re
 }
 
+// generate random file keys in batch
+func GenFileKeysEp(inb *miso.Inbound, req api.GenFileKeysReq) (map[string]string, error) {
+	rail := inb.Rail()
+	timer := miso.NewHistTimer(genFileKeyHisto)
+	defer timer.ObserveDuration()
+
+	files := make([]fstore.CachedFile, 0, len(req.Files))
+	for _, f := range req.Files {
+		fileId := strings.TrimSpace(f.FileId)
+		if fileId == "" {
+			return nil, fstore.ErrFileNotFound
+		}
+		filename := f.Filename
+		if unescaped, err := url.QueryUnescape(f.Filename); err == nil {
+			filename = unescaped
+		}
+		files = append(files, fstore.CachedFile{FileId: fileId, Name: strings.TrimSpace(filename)})
+	}
+
+	opts := fstore.FileKeyOptions{
+		Scope:    strings.TrimSpace(req.Scope),
+		MaxUses:  req.MaxUses,
+		ExpireAt: req.ExpireAt,
+		ClientIp: strings.TrimSpace(req.ClientIp),
+		Referer:  strings.TrimSpace(req.Referer),
+	}
+	return fstore.RandFileKeys(rail, files, opts)
+}
+
+// revoke file key or all file keys of the file
+func RevokeFileKeyEp(inb *miso.Inbound, req api.RevokeFileKeyReq) (any, error) {
+	rail := inb.Rail()
+	key := strings.TrimSpace(req.Key)
+	fileId := strings.TrimSpace(req.FileId)
+	if key == "" && fileId == "" {
+		return nil, fstore.ErrInvalidFileKeyOptions.WithInternalMsg("either key or fileId is required")
+	}
+	if key != "" {
+		if err := fstore.RevokeFileKey(rail, key); err != nil {
+			return nil, err
+		}
+	}
+	if fileId != "" {
+		if err := fstore.RevokeFileKeys(rail, fileId); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
 type FileInfoReq struct {
 	FileId       string `form:"fileId" desc:"actual file_id of the file record"`
 	UploadFileId string `form:"uploadFileId" desc:"temporary file_id returned when uploading files"`
@@ -192,7 +411,7 @@ type FileInfoReq struct {
 func GetFileInfoEp(inb *miso.Inbound, req FileInfoReq) (api.FstoreFile, error) {
 	// fake fileId for uploaded file
 	if req.UploadFileId != "" {
-		rcmd := redis.GetRedis().Get("mini-fstore:upload:fileId:" + req.UploadFileId)
+		rcmd := redis.GetRedis().Get(uploadFileIdKeyPrefix + req.UploadFileId)
 		if rcmd.Err() != nil {
 			if redis.IsNil(rcmd.Err()) {
 				// invalid fileId, or the uploadFileId has expired
@@ -215,16 +434,92 @@ func GetFileInfoEp(inb *miso.Inbound, req FileInfoReq) (api.FstoreFile, error) {
 	if f.IsZero() {
 		return api.FstoreFile{}, fstore.ErrFileNotFound
 	}
+	md, ok, err := fstore.FindFileMetadata(mysql.GetMySQL(), f.FileId)
+	if err != nil {
+		return api.FstoreFile{}, err
+	}
+	ff := toApiFile(f)
+	if ok {
+		ff.Metadata = md.ToApi()
+	}
+	return ff, nil
+}
+
+func toApiFile(f fstore.File) api.FstoreFile {
 	return api.FstoreFile{
 		FileId:     f.FileId,
 		Name:       f.Name,
 		Status:     f.Status,
 		Size:       f.Size,
 		Md5:        f.Md5,
+		Sha1:       f.Sha1,
 		UplTime:    f.UplTime,
 		LogDelTime: f.LogDelTime,
 		PhyDelTime: f.PhyDelTime,
-	}, nil
+	}
+}
+
+// Get info of files in batch, one result is returned for each requested fileId and uploadFileId in order.
+func GetFileInfosEp(inb *miso.Inbound, req api.FetchFileInfosReq) ([]api.FileInfoResult, error) {
+	if len(req.FileIds)+len(req.UploadFileIds) > fstore.MaxBatchSize {
+		return nil, fstore.ErrBatchTooLarge.WithInternalMsg("batch size: %v", len(req.FileIds)+len(req.UploadFileIds))
+	}
+
+	results := make([]api.FileInfoResult, 0, len(req.FileIds)+len(req.UploadFileIds))
+	resolved := make([]string, 0, cap(results)) // actual file_id of each result
+	for _, id := range req.FileIds {
+		results = append(results, api.FileInfoResult{FileId: id})
+		resolved = append(resolved, id)
+	}
+
+	// resolve the actual file_ids of the uploadFileIds in one round trip
+	if len(req.UploadFileIds) > 0 {
+		keys := make([]string, 0, len(req.UploadFileIds))
+		for _, id := range req.UploadFileIds {
+			keys = append(keys, uploadFileIdKeyPrefix+id)
+		}
+		cmd := redis.GetRedis().MGet(keys...)
+		if cmd.Err() != nil {
+			return nil, fmt.Errorf("failed to resolve uploadFileIds, %v", cmd.Err())
+		}
+		for i, v := range cmd.Val() {
+			fileId, _ := v.(string) // nil if the uploadFileId is invalid or has expired
+			results = append(results, api.FileInfoResult{UploadFileId: req.UploadFileIds[i]})
+			resolved = append(resolved, fileId)
+		}
+	}
+
+	fileIds := make([]string, 0, len(resolved))
+	for _, id := range resolved {
+		if id != "" {
+			fileIds = append(fileIds, id)
+		}
+	}
+	fileIds = util.Distinct(fileIds)
+
+	db := mysql.GetMySQL()
+	files, err := fstore.FindFiles(db, fileIds)
+	if err != nil {
+		return nil, err
+	}
+	mds, err := fstore.FindFilesMetadata(db, fileIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		f, ok := files[resolved[i]]
+		if !ok {
+			continue
+		}
+		ff := toApiFile(f)
+		if md, ok := mds[f.FileId]; ok {
+			ff.Metadata = md.ToApi()
+		}
+		results[i].Found = true
+		results[i].File = &ff
+	}
+	return results, nil
 }
 
 func UploadFileEp(inb *miso.Inbound) (string, error) {
@@ -248,7 +543,7 @@ func UploadFileEp(inb *miso.Inbound) (string, error) {
 	// the fileId should be used internally within the system)
 	tempFileId := util.ERand(40)
 
-	cmd := redis.GetRedis().Set("mini-fstore:upload:fileId:"+tempFileId, fileId, 6*time.Hour)
+	cmd := redis.GetRedis().Set(uploadFileIdKeyPrefix+tempFileId, fileId, 6*time.Hour)
 	if cmd.Err() != nil {
 		return "", fmt.Errorf("failed to cache the generated fake fileId, %v", e)
 	}
@@ -261,13 +556,18 @@ func UploadFileEp(inb *miso.Inbound) (string, error) {
 func TempKeyDownloadFileEp(inb *miso.Inbound) {
 	rail := inb.Rail()
 	w, r := inb.Unwrap()
-	key := strings.TrimSpace(r.URL.Query().Get("key"))
+	query := r.URL.Query()
+	key := strings.TrimSpace(query.Get("key"))
 	if key == "" {
 		w.WriteHeader(404)
 		return
 	}
 
-	if e := fstore.DownloadFileKey(rail, w, key); e != nil {
+	if served := serveImageVariant(rail, w, r, key, true); served {
+		return
+	}
+
+	if e := fstore.DownloadFileKey(rail, w, r, key); e != nil {
 		rail.Warnf("Failed to download by fileKey, %v", e)
 		w.WriteHeader(404)
 		return
@@ -285,13 +585,116 @@ func TempKeyStreamFileEp(inb *miso.Inbound) {
 		return
 	}
 
-	if e := fstore.StreamFileKey(rail, w, key, parseByteRangeRequest(r)); e != nil {
+	if served := serveImageVariant(rail, w, r, key, false); served {
+		return
+	}
+
+	if e := fstore.StreamFileKey(rail, w, r, key, parseByteRangeRequest(r)); e != nil {
 		rail.Warnf("Failed to stream by fileKey, %v", e)
 		w.WriteHeader(404)
 		return
 	}
 }
 
+// Stream HLS playlists and segments
+func TempKeyHlsEp(inb *miso.Inbound) {
+	rail := inb.Rail()
+	w, r := inb.Unwrap()
+	query := r.URL.Query()
+	key := strings.TrimSpace(query.Get("key"))
+	if key == "" {
+		w.WriteHeader(404)
+		return
+	}
+
+	if e := hammer.ServeHls(rail, w, r, key, strings.TrimSpace(query.Get("f"))); e != nil {
+		rail.Warnf("Failed to serve HLS by fileKey, %v", e)
+		if errors.Is(e, hammer.ErrNotHlsPlaylist) {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		w.WriteHeader(404)
+		return
+	}
+}
+
+// Serve transformed image if transform parameters are present, returns false if the request is not handled.
+func serveImageVariant(rail miso.Rail, w http.ResponseWriter, r *http.Request, key string, attachment bool) bool {
+	t, err := hammer.ParseImageTransform(r.URL.Query())
+	if err != nil {
+		rail.Warnf("Failed to parse image transform, %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return true
+	}
+	if t.IsZero() {
+		return false
+	}
+
+	if e := hammer.ServeImageVariant(rail, w, r, key, t, attachment); e != nil {
+		rail.Warnf("Failed to serve image variant by fileKey, %v", e)
+		if errors.Is(e, hammer.ErrNotImage) || errors.Is(e, hammer.ErrUnsupportedImageFormat) {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return true
+		}
+		if errors.Is(e, hammer.ErrImageTooLarge) {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return true
+		}
+		w.WriteHeader(404)
+	}
+	return true
+}
+
+func StripFileMetadataEp(inb *miso.Inbound, req api.StripMetadataReq) (string, error) {
+	rail := inb.Rail()
+	return hammer.StripImageMetadata(rail, req.FileId)
+}
+
+func ExtractFileMetadataEp(inb *miso.Inbound, req api.ExtractMetadataReq) (any, error) {
+	rail := inb.Rail()
+	return nil, fstore.TriggerMetadataExtraction(rail, mysql.GetMySQL(), req.FileId)
+}
+
+func ListDerivativesEp(inb *miso.Inbound, req api.ListDerivativesReq) (map[string]string, error) {
+	return fstore.FindDerivatives(inb.Rail(), mysql.GetMySQL(), req.SourceSha1, req.Operation, req.Params)
+}
+
+func SaveDerivativeEp(inb *miso.Inbound, req api.SaveDerivativeReq) (any, error) {
+	db := mysql.GetMySQL()
+	d := fstore.Derivative{
+		SourceSha1:   req.SourceSha1,
+		SourceFileId: req.SourceFileId,
+		Operation:    req.Operation,
+		Params:       req.Params,
+		FileId:       req.FileId,
+	}
+	if err := fstore.CheckDerivative(db, d); err != nil {
+		return nil, err
+	}
+	return nil, fstore.SaveDerivative(inb.Rail(), db, d)
+}
+
+func LinkFileEp(inb *miso.Inbound, req api.LinkFileReq) (string, error) {
+	return fstore.LinkFile(inb.Rail(), mysql.GetMySQL(), req.FileId, req.Name)
+}
+
+func SaveHlsFilesEp(inb *miso.Inbound, req api.SaveHlsFilesReq) (any, error) {
+	return nil, fstore.SaveHlsFiles(inb.Rail(), mysql.GetMySQL(), req.PlaylistFileId, req.Files)
+}
+
+func SaveFileMetadataEp(inb *miso.Inbound, req api.SaveFileMetadataReq) (any, error) {
+	rail := inb.Rail()
+	db := mysql.GetMySQL()
+	f, err := fstore.FindFile(db, req.FileId)
+	if err != nil {
+		return nil, err
+	}
+	if f.IsZero() {
+		return nil, fstore.ErrFileNotFound
+	}
+	return nil, fstore.SaveFileMetadata(rail, db, fstore.FileMetadataFromApi(req.FileId, req.Metadata))
+}
+
 func UnzipFileEp(inb *miso.Inbound, req api.UnzipFileReq) (any, error) {
 	rail := inb.Rail()
 	return nil, fstore.TriggerUnzipFilePipeline(rail, mysql.GetMySQL(), req)
@@ -364,12 +767,22 @@ func parseByteRangeHeader(rangeHeader string) fstore.ByteRange {
 
 func RemoveDeletedFilesEp(inb *miso.Inbound) (any, error) {
 	rail := inb.Rail()
-	return nil, fstore.RemoveDeletedFiles(rail, mysql.GetMySQL())
+	return fstore.SubmitMaintenanceJob(rail, mysql.GetMySQL(), fstore.JobRemoveDeletedFiles)
 }
 
 func SanitizeStorageEp(inb *miso.Inbound) (any, error) {
 	rail := inb.Rail()
-	return nil, fstore.SanitizeStorage(rail)
+	return fstore.SubmitMaintenanceJob(rail, mysql.GetMySQL(), fstore.JobSanitizeStorage)
+}
+
+func RepairBlobsEp(inb *miso.Inbound) (any, error) {
+	rail := inb.Rail()
+	return fstore.SubmitMaintenanceJob(rail, mysql.GetMySQL(), fstore.JobRepairBlobs)
+}
+
+func PurgeTrashEp(inb *miso.Inbound) (any, error) {
+	rail := inb.Rail()
+	return fstore.SubmitMaintenanceJob(rail, mysql.GetMySQL(), fstore.JobPurgeTrash)
 }
 
 func DirectDownloadFileEp(inb *miso.Inbound) {
@@ -395,5 +808,40 @@ func getAuthorization(r *http.Request) string {
 
 func ComputeChecksumEp(inb *miso.Inbound) (any, error) {
 	rail := inb.Rail()
-	return nil, fstore.ComputeFilesChecksum(rail, mysql.GetMySQL())
+	return fstore.SubmitMaintenanceJob(rail, mysql.GetMySQL(), fstore.JobComputeChecksum)
+}
+
+type SubmitMaintenanceJobReq struct {
+	Name string `json:"name" valid:"notEmpty" desc:"job name: remove-deleted, sanitize-storage, compute-checksum, repair-blobs or purge-trash"`
+}
+
+func SubmitMaintenanceJobEp(inb *miso.Inbound, req SubmitMaintenanceJobReq) (string, error) {
+	rail := inb.Rail()
+	return fstore.SubmitMaintenanceJob(rail, mysql.GetMySQL(), req.Name)
+}
+
+type GetMaintenanceJobReq struct {
+	JobId string `form:"jobId" valid:"notEmpty" desc:"job id"`
+}
+
+func GetMaintenanceJobEp(inb *miso.Inbound, req GetMaintenanceJobReq) (fstore.MaintenanceJob, error) {
+	return fstore.FindMaintenanceJob(mysql.GetMySQL(), req.JobId)
+}
+
+type CancelMaintenanceJobReq struct {
+	JobId string `json:"jobId" valid:"notEmpty" desc:"job id"`
+}
+
+func CancelMaintenanceJobEp(inb *miso.Inbound, req CancelMaintenanceJobReq) (any, error) {
+	rail := inb.Rail()
+	return nil, fstore.CancelMaintenanceJob(rail, mysql.GetMySQL(), req.JobId)
+}
+
+type ListMaintenanceJobsReq struct {
+	Name  string `form:"name" desc:"job name, jobs of all names are listed if empty"`
+	Limit int    `form:"limit" desc:"max number of jobs listed (at most 100)"`
+}
+
+func ListMaintenanceJobsEp(inb *miso.Inbound, req ListMaintenanceJobsReq) ([]fstore.MaintenanceJob, error) {
+	return fstore.ListMaintenanceJobs(mysql.GetMySQL(), req.Name, req.Limit)
 }
@@ -0,0 +1,52 @@
+CREATE TABLE mini_fstore.file_metadata (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `file_id` varchar(32) NOT NULL COMMENT 'file id',
+  `format` varchar(64) NOT NULL DEFAULT '' COMMENT 'media format',
+  `width` int NOT NULL DEFAULT 0 COMMENT 'width in pixels',
+  `height` int NOT NULL DEFAULT 0 COMMENT 'height in pixels',
+  `capture_time` timestamp NULL DEFAULT NULL COMMENT 'when the photo was taken',
+  `camera_model` varchar(128) NOT NULL DEFAULT '' COMMENT 'camera model',
+  `duration_ms` bigint(20) NOT NULL DEFAULT 0 COMMENT 'duration in milliseconds',
+  `video_codec` varchar(32) NOT NULL DEFAULT '' COMMENT 'video codec',
+  `audio_codec` varchar(32) NOT NULL DEFAULT '' COMMENT 'audio codec',
+  `bitrate` bigint(20) NOT NULL DEFAULT 0 COMMENT 'bitrate in bits per second',
+  `ctime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+  `utime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `file_id_uk` (`file_id`)
+) ENGINE=InnoDB COMMENT='File Media Metadata';
+
+CREATE TABLE mini_fstore.file_derivative (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `source_sha1` varchar(40) NOT NULL COMMENT 'sha1 of the source content',
+  `source_file_id` varchar(32) NOT NULL DEFAULT '' COMMENT 'file id of the source file',
+  `operation` varchar(32) NOT NULL COMMENT 'operation applied to the source content',
+  `params` varchar(255) NOT NULL DEFAULT '' COMMENT 'parameters of the operation',
+  `file_id` varchar(32) NOT NULL COMMENT 'file id of the derived file',
+  `ctime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+  `utime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `source_op_params_uk` (`source_sha1`, `operation`, `params`),
+  KEY `source_file_id_idx` (`source_file_id`, `operation`)
+) ENGINE=InnoDB COMMENT='Derived files generated from file content';
+
+alter table mini_fstore.file add column `trash_time` timestamp NULL DEFAULT NULL COMMENT 'time when the file is moved to trash dir';
+
+CREATE TABLE mini_fstore.maintenance_job (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key',
+  `job_id` varchar(32) NOT NULL COMMENT 'job id',
+  `name` varchar(32) NOT NULL COMMENT 'job name',
+  `status` varchar(16) NOT NULL COMMENT 'status',
+  `node` varchar(128) NOT NULL DEFAULT '' COMMENT 'node running the job',
+  `total` bigint(20) NOT NULL DEFAULT 0 COMMENT 'total number of items to process, 0 if unknown',
+  `processed` bigint(20) NOT NULL DEFAULT 0 COMMENT 'number of items processed',
+  `failed` bigint(20) NOT NULL DEFAULT 0 COMMENT 'number of items failed',
+  `error` varchar(1000) NOT NULL DEFAULT '' COMMENT 'error message',
+  `start_time` timestamp NULL DEFAULT NULL COMMENT 'start time',
+  `end_time` timestamp NULL DEFAULT NULL COMMENT 'end time',
+  `ctime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT 'created at',
+  `utime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'updated at',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `job_id_uk` (`job_id`),
+  KEY `name_status_idx` (`name`, `status`)
+) ENGINE=InnoDB COMMENT='Maintenance Job';